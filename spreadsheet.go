@@ -0,0 +1,46 @@
+/*
+File Name:  spreadsheet.go
+Copyright:  2019 Kleissner Investments s.r.o.
+Author:     Peter Kleissner
+
+Top-level dispatcher that sniffs the spreadsheet format (legacy XLS vs. OOXML XLSX) so callers
+don't have to branch between xls2txt and xlsx2txt themselves.
+*/
+
+package fileconversion
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/FleetWiser/fileconversion/xls2txt"
+	"github.com/FleetWiser/fileconversion/xlsx2txt"
+)
+
+// ErrUnrecognizedFormat is returned when data is neither a XLS nor a XLSX file.
+var ErrUnrecognizedFormat = errors.New("unrecognized spreadsheet format")
+
+// Spreadsheet2Text extracts text from an Excel file, routing to the XLS or XLSX backend based on the file's magic bytes.
+func Spreadsheet2Text(data []byte, writer io.Writer, size int64) (written int64, err error) {
+	switch {
+	case xlsx2txt.IsFileXLSX(data):
+		return xlsx2txt.XLSX2Text(bytes.NewReader(data), writer, size)
+	case xls2txt.IsFileXLS(data):
+		return xls2txt.XLS2Text(bytes.NewReader(data), writer, size)
+	default:
+		return 0, ErrUnrecognizedFormat
+	}
+}
+
+// Spreadsheet2CSV converts the selected sheet of an Excel file into CSV format, routing to the XLS or XLSX backend based on the file's magic bytes.
+func Spreadsheet2CSV(data []byte, sheetNumber int) ([]byte, error) {
+	switch {
+	case xlsx2txt.IsFileXLSX(data):
+		return xlsx2txt.XLSX2CSV(bytes.NewReader(data), sheetNumber)
+	case xls2txt.IsFileXLS(data):
+		return xls2txt.XLS2CSV(bytes.NewReader(data), sheetNumber)
+	default:
+		return nil, ErrUnrecognizedFormat
+	}
+}
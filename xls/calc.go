@@ -0,0 +1,598 @@
+/*
+File Name:  calc.go
+Copyright:  2019 Kleissner Investments s.r.o.
+Author:     Peter Kleissner
+
+Minimal formula evaluator for the subset of Excel functions that show up most often in BIFF8
+worksheets: arithmetic, IF, SUM, AVERAGE, CONCATENATE, DATE, DATEDIF, VLOOKUP and TEXT. Modeled on
+excelize's calc.go. FormattedCol itself doesn't use this -- it renders a formula cell's cached
+result (extracted straight from the FORMULA record by BuildCellFormats) rather than re-evaluating
+the formula text, since a cached result already accounts for cross-cell references this evaluator
+has no access to. EvaluateFormula is exported for callers who do have formula text in hand and want
+it evaluated against their own CellResolver.
+*/
+
+package xls
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnsupportedFormula is returned for formula syntax or functions this evaluator doesn't implement.
+var ErrUnsupportedFormula = errors.New("xls: unsupported formula")
+
+// CellResolver looks up the raw value of a cell reference (e.g. "A1") or the values covered by a
+// range reference (e.g. "A1:A10"), so the formula evaluator can resolve SUM/AVERAGE/VLOOKUP args.
+type CellResolver interface {
+	Cell(ref string) string
+	Range(ref string) []string
+}
+
+// EvaluateFormula evaluates an Excel formula (without the leading "=") against resolver and
+// returns its result rendered as text.
+func EvaluateFormula(formula string, resolver CellResolver) (result string, err error) {
+	p := &formulaParser{input: formula, resolver: resolver}
+	val, err := p.parseExpr()
+	if err != nil {
+		return "", err
+	}
+	p.skipSpace()
+	if p.pos != len(p.input) {
+		return "", fmt.Errorf("%w: trailing input %q", ErrUnsupportedFormula, p.input[p.pos:])
+	}
+	return val.text(), nil
+}
+
+// formulaValue is either a number or a string; Excel formulas coerce freely between the two.
+type formulaValue struct {
+	isNumber bool
+	num      float64
+	str      string
+}
+
+func numberValue(n float64) formulaValue { return formulaValue{isNumber: true, num: n} }
+func stringValue(s string) formulaValue  { return formulaValue{str: s} }
+
+func (v formulaValue) text() string {
+	if v.isNumber {
+		return strconv.FormatFloat(v.num, 'f', -1, 64)
+	}
+	return v.str
+}
+
+func (v formulaValue) asNumber() (float64, error) {
+	if v.isNumber {
+		return v.num, nil
+	}
+	return strconv.ParseFloat(strings.TrimSpace(v.str), 64)
+}
+
+type formulaParser struct {
+	input    string
+	pos      int
+	resolver CellResolver
+}
+
+func (p *formulaParser) skipSpace() {
+	for p.pos < len(p.input) && p.input[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *formulaParser) peek() byte {
+	if p.pos >= len(p.input) {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+// parseExpr handles + and - at the lowest precedence.
+func (p *formulaParser) parseExpr() (formulaValue, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return formulaValue{}, err
+	}
+
+	for {
+		p.skipSpace()
+		op := p.peek()
+		if op == '&' {
+			p.pos++
+			right, err := p.parseTerm()
+			if err != nil {
+				return formulaValue{}, err
+			}
+			left = stringValue(left.text() + right.text())
+			continue
+		}
+		if op != '+' && op != '-' {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseTerm()
+		if err != nil {
+			return formulaValue{}, err
+		}
+
+		ln, err := left.asNumber()
+		if err != nil {
+			return formulaValue{}, err
+		}
+		rn, err := right.asNumber()
+		if err != nil {
+			return formulaValue{}, err
+		}
+		if op == '+' {
+			left = numberValue(ln + rn)
+		} else {
+			left = numberValue(ln - rn)
+		}
+	}
+}
+
+// parseTerm handles * and /.
+func (p *formulaParser) parseTerm() (formulaValue, error) {
+	left, err := p.parseFactor()
+	if err != nil {
+		return formulaValue{}, err
+	}
+
+	for {
+		p.skipSpace()
+		op := p.peek()
+		if op != '*' && op != '/' {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseFactor()
+		if err != nil {
+			return formulaValue{}, err
+		}
+
+		ln, err := left.asNumber()
+		if err != nil {
+			return formulaValue{}, err
+		}
+		rn, err := right.asNumber()
+		if err != nil {
+			return formulaValue{}, err
+		}
+		if op == '*' {
+			left = numberValue(ln * rn)
+		} else {
+			if rn == 0 {
+				return formulaValue{}, errors.New("xls: division by zero")
+			}
+			left = numberValue(ln / rn)
+		}
+	}
+}
+
+// parseFactor handles parentheses, numbers, string literals, function calls and cell references.
+func (p *formulaParser) parseFactor() (formulaValue, error) {
+	p.skipSpace()
+
+	if p.peek() == '(' {
+		p.pos++
+		val, err := p.parseExpr()
+		if err != nil {
+			return formulaValue{}, err
+		}
+		p.skipSpace()
+		if p.peek() != ')' {
+			return formulaValue{}, fmt.Errorf("%w: expected )", ErrUnsupportedFormula)
+		}
+		p.pos++
+		return val, nil
+	}
+
+	if p.peek() == '"' {
+		return p.parseStringLiteral()
+	}
+
+	if isIdentStart(p.peek()) {
+		return p.parseIdentOrCall()
+	}
+
+	return p.parseNumber()
+}
+
+func (p *formulaParser) parseStringLiteral() (formulaValue, error) {
+	p.pos++ // opening quote
+	start := p.pos
+	for p.pos < len(p.input) && p.input[p.pos] != '"' {
+		p.pos++
+	}
+	if p.pos >= len(p.input) {
+		return formulaValue{}, fmt.Errorf("%w: unterminated string", ErrUnsupportedFormula)
+	}
+	s := p.input[start:p.pos]
+	p.pos++ // closing quote
+	return stringValue(s), nil
+}
+
+func (p *formulaParser) parseNumber() (formulaValue, error) {
+	start := p.pos
+	for p.pos < len(p.input) && (isDigit(p.input[p.pos]) || p.input[p.pos] == '.') {
+		p.pos++
+	}
+	if p.pos == start {
+		return formulaValue{}, fmt.Errorf("%w: unexpected character %q", ErrUnsupportedFormula, p.peek())
+	}
+	n, err := strconv.ParseFloat(p.input[start:p.pos], 64)
+	if err != nil {
+		return formulaValue{}, err
+	}
+	return numberValue(n), nil
+}
+
+// parseIdentOrCall parses a cell/range reference (A1, A1:A10) or a function call (SUM(...)).
+func (p *formulaParser) parseIdentOrCall() (formulaValue, error) {
+	start := p.pos
+	for p.pos < len(p.input) && isIdentChar(p.input[p.pos]) {
+		p.pos++
+	}
+	ident := p.input[start:p.pos]
+
+	p.skipSpace()
+	if p.peek() == '(' {
+		p.pos++
+		args, err := p.parseArgs()
+		if err != nil {
+			return formulaValue{}, err
+		}
+		return callFunction(strings.ToUpper(ident), args, p.resolver)
+	}
+
+	if p.peek() == ':' {
+		rangeStart := ident
+		p.pos++
+		refStart := p.pos
+		for p.pos < len(p.input) && isIdentChar(p.input[p.pos]) {
+			p.pos++
+		}
+		ref := rangeStart + ":" + p.input[refStart:p.pos]
+		return stringValue(ref), nil
+	}
+
+	return stringValue(p.resolver.Cell(ident)), nil
+}
+
+// parseArgs parses a comma-separated argument list up to the closing paren, returning each
+// argument as formula text (ranges and cell refs are kept as raw references for functions like
+// SUM/VLOOKUP that need to resolve them against the sheet rather than a single cell's value).
+func (p *formulaParser) parseArgs() (args []string, err error) {
+	p.skipSpace()
+	if p.peek() == ')' {
+		p.pos++
+		return nil, nil
+	}
+
+	for {
+		start := p.pos
+		depth := 0
+		for p.pos < len(p.input) {
+			c := p.input[p.pos]
+			if c == '(' {
+				depth++
+			} else if c == ')' {
+				if depth == 0 {
+					break
+				}
+				depth--
+			} else if c == ',' && depth == 0 {
+				break
+			}
+			p.pos++
+		}
+		args = append(args, strings.TrimSpace(p.input[start:p.pos]))
+
+		if p.peek() == ',' {
+			p.pos++
+			continue
+		}
+		if p.peek() == ')' {
+			p.pos++
+			return args, nil
+		}
+		return nil, fmt.Errorf("%w: expected , or )", ErrUnsupportedFormula)
+	}
+}
+
+func isDigit(c byte) bool      { return c >= '0' && c <= '9' }
+func isIdentStart(c byte) bool { return (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') }
+func isIdentChar(c byte) bool  { return isIdentStart(c) || isDigit(c) || c == '$' }
+
+// callFunction evaluates one of the supported built-in functions against its raw argument text.
+func callFunction(name string, args []string, resolver CellResolver) (formulaValue, error) {
+	switch name {
+	case "SUM", "AVERAGE":
+		values, err := resolveNumbers(args, resolver)
+		if err != nil {
+			return formulaValue{}, err
+		}
+		if len(values) == 0 {
+			return numberValue(0), nil
+		}
+		sum := 0.0
+		for _, v := range values {
+			sum += v
+		}
+		if name == "AVERAGE" {
+			return numberValue(sum / float64(len(values))), nil
+		}
+		return numberValue(sum), nil
+
+	case "CONCATENATE":
+		var sb strings.Builder
+		for _, a := range args {
+			v, err := evalArg(a, resolver)
+			if err != nil {
+				return formulaValue{}, err
+			}
+			sb.WriteString(v.text())
+		}
+		return stringValue(sb.String()), nil
+
+	case "IF":
+		if len(args) < 2 || len(args) > 3 {
+			return formulaValue{}, fmt.Errorf("%w: IF takes 2 or 3 arguments", ErrUnsupportedFormula)
+		}
+		cond, err := evalCondition(args[0], resolver)
+		if err != nil {
+			return formulaValue{}, err
+		}
+		if cond {
+			return evalArg(args[1], resolver)
+		}
+		if len(args) == 3 {
+			return evalArg(args[2], resolver)
+		}
+		return stringValue(""), nil
+
+	case "DATE":
+		if len(args) != 3 {
+			return formulaValue{}, fmt.Errorf("%w: DATE takes 3 arguments", ErrUnsupportedFormula)
+		}
+		y, m, d, err := evalYMD(args, resolver)
+		if err != nil {
+			return formulaValue{}, err
+		}
+		return stringValue(time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC).Format("2006-01-02")), nil
+
+	case "DATEDIF":
+		if len(args) != 3 {
+			return formulaValue{}, fmt.Errorf("%w: DATEDIF takes 3 arguments", ErrUnsupportedFormula)
+		}
+		start, err := evalDate(args[0], resolver)
+		if err != nil {
+			return formulaValue{}, err
+		}
+		end, err := evalDate(args[1], resolver)
+		if err != nil {
+			return formulaValue{}, err
+		}
+		unitVal, err := evalArg(args[2], resolver)
+		if err != nil {
+			return formulaValue{}, err
+		}
+		n, err := DateDif(start, end, strings.Trim(unitVal.text(), "\""))
+		if err != nil {
+			return formulaValue{}, err
+		}
+		return numberValue(float64(n)), nil
+
+	case "VLOOKUP":
+		if len(args) < 3 {
+			return formulaValue{}, fmt.Errorf("%w: VLOOKUP takes at least 3 arguments", ErrUnsupportedFormula)
+		}
+		lookup, err := evalArg(args[0], resolver)
+		if err != nil {
+			return formulaValue{}, err
+		}
+		table := resolver.Range(args[1])
+		colVal, err := evalArg(args[2], resolver)
+		if err != nil {
+			return formulaValue{}, err
+		}
+		col, err := colVal.asNumber()
+		if err != nil {
+			return formulaValue{}, err
+		}
+		return vlookup(lookup.text(), table, int(col))
+
+	case "TEXT":
+		if len(args) != 2 {
+			return formulaValue{}, fmt.Errorf("%w: TEXT takes 2 arguments", ErrUnsupportedFormula)
+		}
+		val, err := evalArg(args[0], resolver)
+		if err != nil {
+			return formulaValue{}, err
+		}
+		formatVal, err := evalArg(args[1], resolver)
+		if err != nil {
+			return formulaValue{}, err
+		}
+		return stringValue(ApplyNumberFormat(val.text(), strings.Trim(formatVal.text(), "\""))), nil
+
+	default:
+		return formulaValue{}, fmt.Errorf("%w: %s", ErrUnsupportedFormula, name)
+	}
+}
+
+// evalArg evaluates a sub-expression given as raw formula text (used for function arguments).
+func evalArg(expr string, resolver CellResolver) (formulaValue, error) {
+	p := &formulaParser{input: expr, resolver: resolver}
+	return p.parseExpr()
+}
+
+func evalCondition(expr string, resolver CellResolver) (bool, error) {
+	for _, op := range []string{">=", "<=", "<>", "=", ">", "<"} {
+		if idx := strings.Index(expr, op); idx > 0 {
+			left, err := evalArg(expr[:idx], resolver)
+			if err != nil {
+				continue
+			}
+			right, err := evalArg(expr[idx+len(op):], resolver)
+			if err != nil {
+				continue
+			}
+			return compare(left, right, op)
+		}
+	}
+	v, err := evalArg(expr, resolver)
+	if err != nil {
+		return false, err
+	}
+	n, err := v.asNumber()
+	if err == nil {
+		return n != 0, nil
+	}
+	return v.text() != "", nil
+}
+
+func compare(left, right formulaValue, op string) (bool, error) {
+	ln, lerr := left.asNumber()
+	rn, rerr := right.asNumber()
+	if lerr == nil && rerr == nil {
+		switch op {
+		case "=":
+			return ln == rn, nil
+		case "<>":
+			return ln != rn, nil
+		case ">":
+			return ln > rn, nil
+		case "<":
+			return ln < rn, nil
+		case ">=":
+			return ln >= rn, nil
+		case "<=":
+			return ln <= rn, nil
+		}
+	}
+
+	ls, rs := left.text(), right.text()
+	switch op {
+	case "=":
+		return ls == rs, nil
+	case "<>":
+		return ls != rs, nil
+	default:
+		return false, fmt.Errorf("%w: cannot compare %q %s %q", ErrUnsupportedFormula, ls, op, rs)
+	}
+}
+
+func evalYMD(args []string, resolver CellResolver) (y, m, d int, err error) {
+	vals := make([]float64, 3)
+	for i, a := range args {
+		v, err := evalArg(a, resolver)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		vals[i], err = v.asNumber()
+		if err != nil {
+			return 0, 0, 0, err
+		}
+	}
+	return int(vals[0]), int(vals[1]), int(vals[2]), nil
+}
+
+func evalDate(expr string, resolver CellResolver) (time.Time, error) {
+	v, err := evalArg(expr, resolver)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse("2006-01-02", strings.TrimSpace(v.text()))
+}
+
+func resolveNumbers(args []string, resolver CellResolver) (values []float64, err error) {
+	for _, a := range args {
+		if strings.Contains(a, ":") {
+			for _, cell := range resolver.Range(a) {
+				if cell == "" {
+					continue
+				}
+				n, err := strconv.ParseFloat(strings.TrimSpace(cell), 64)
+				if err != nil {
+					continue
+				}
+				values = append(values, n)
+			}
+			continue
+		}
+
+		v, err := evalArg(a, resolver)
+		if err != nil {
+			return nil, err
+		}
+		n, err := v.asNumber()
+		if err != nil {
+			continue
+		}
+		values = append(values, n)
+	}
+	return values, nil
+}
+
+func vlookup(lookup string, table []string, col int) (formulaValue, error) {
+	if col < 1 {
+		return formulaValue{}, fmt.Errorf("%w: VLOOKUP column index must be >= 1", ErrUnsupportedFormula)
+	}
+	for i := 0; i+col-1 < len(table); i++ {
+		if table[i] == lookup {
+			return stringValue(table[i+col-1]), nil
+		}
+	}
+	return formulaValue{}, fmt.Errorf("%w: VLOOKUP found no match for %q", ErrUnsupportedFormula, lookup)
+}
+
+// DateDif replicates Excel's DATEDIF for the "d", "m", "y", "md", "ym" and "yd" units.
+func DateDif(start, end time.Time, unit string) (int, error) {
+	switch strings.ToLower(unit) {
+	case "d":
+		return int(end.Sub(start).Hours() / 24), nil
+
+	case "m":
+		months := (end.Year()-start.Year())*12 + int(end.Month()) - int(start.Month())
+		if end.Day() < start.Day() {
+			months--
+		}
+		return months, nil
+
+	case "y":
+		years := end.Year() - start.Year()
+		if end.Month() < start.Month() || (end.Month() == start.Month() && end.Day() < start.Day()) {
+			years--
+		}
+		return years, nil
+
+	case "md":
+		if end.Day() >= start.Day() {
+			return end.Day() - start.Day(), nil
+		}
+		// Borrow from the month before end's: days remaining in it, plus end's day count.
+		prevMonthEnd := time.Date(end.Year(), end.Month(), 0, 0, 0, 0, 0, time.UTC)
+		return prevMonthEnd.Day() - start.Day() + end.Day(), nil
+
+	case "ym":
+		months := (end.Year()-start.Year())*12 + int(end.Month()) - int(start.Month())
+		if end.Day() < start.Day() {
+			months--
+		}
+		return ((months % 12) + 12) % 12, nil
+
+	case "yd":
+		s := time.Date(end.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+		if end.Month() < start.Month() || (end.Month() == start.Month() && end.Day() < start.Day()) {
+			s = time.Date(end.Year()-1, start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+		}
+		return int(end.Sub(s).Hours() / 24), nil
+
+	default:
+		return 0, fmt.Errorf("xls: unknown DATEDIF unit %q", unit)
+	}
+}
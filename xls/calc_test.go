@@ -0,0 +1,46 @@
+/*
+File Name:  calc_test.go
+Copyright:  2019 Kleissner Investments s.r.o.
+Author:     Peter Kleissner
+*/
+
+package xls
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDateDif(t *testing.T) {
+	date := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name       string
+		start, end time.Time
+		unit       string
+		want       int
+	}{
+		{"d", date(2024, 1, 20), date(2024, 3, 15), "d", 55},
+		{"m", date(2024, 1, 20), date(2024, 3, 15), "m", 1},
+		{"y", date(2020, 6, 15), date(2024, 3, 10), "y", 3},
+		{"md same month or later day", date(2024, 1, 20), date(2024, 3, 25), "md", 5},
+		{"md borrow across month", date(2024, 1, 20), date(2024, 3, 15), "md", 24},
+		{"ym", date(2024, 1, 20), date(2024, 3, 15), "ym", 1},
+		{"yd same year span", date(2024, 1, 20), date(2024, 3, 15), "yd", 55},
+		{"yd multi-year span", date(2020, 6, 15), date(2024, 3, 10), "yd", 269},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := DateDif(tc.start, tc.end, tc.unit)
+			if err != nil {
+				t.Fatalf("DateDif returned error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("DateDif(%s, %s, %q) = %d, want %d", tc.start, tc.end, tc.unit, got, tc.want)
+			}
+		})
+	}
+}
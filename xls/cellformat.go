@@ -0,0 +1,377 @@
+/*
+File Name:  cellformat.go
+Copyright:  2019 Kleissner Investments s.r.o.
+Author:     Peter Kleissner
+
+BuildCellFormats scans a workbook's raw BIFF8 records for the two things FormattedCol needs but
+Row doesn't carry: each cell's XF number-format code (from the FORMAT/XF records in the Workbook
+Globals substream, keyed to cells via the XF index every cell-value record stores) and, for formula
+cells, the cached result already computed by whatever program last saved the file (from the
+FORMULA record, and the STRING record that follows it for a string-valued result).
+
+This intentionally doesn't recompile a FORMULA record's RPN token stream (rgce) back into formula
+text -- doing that right needs the full Ptg function-id table and is a separate undertaking from
+rendering a cell's displayed value, which is what callers actually want. Using the cached
+result also means formulas that reference other cells render correctly without this package needing
+any cross-sheet context, which EvaluateFormula alone can't provide.
+*/
+
+package xls
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"strconv"
+)
+
+const (
+	recFormat   = 0x041E
+	recXF       = 0x00E0
+	recBOF      = 0x0809
+	recString   = 0x0207
+	recNumber   = 0x0203
+	recRK       = 0x027E
+	recMulRK    = 0x00BD
+	recMulBlank = 0x00BE
+	recLabelSST = 0x00FD
+	recLabel    = 0x0204
+	recFormula  = 0x0006
+	recBlank    = 0x0201
+	recBoolErr  = 0x0205
+)
+
+// bofDocType is the BOF record's "document type" field, at payload offset 2.
+type bofDocType uint16
+
+const (
+	bofDocTypeWorkbookGlobals bofDocType = 0x0005
+	bofDocTypeWorksheet       bofDocType = 0x0010
+)
+
+// builtinNumberFormats covers the built-in XF number-format codes ([MS-XLS] 2.4.126) that matter
+// for rendering dates, currency and percentages; everything else without a matching FORMAT record
+// renders as "General" (i.e. unchanged).
+var builtinNumberFormats = map[int]string{
+	1:  "0",
+	2:  "0.00",
+	3:  "#,##0",
+	4:  "#,##0.00",
+	9:  "0%",
+	10: "0.00%",
+	14: "m/d/yyyy",
+	15: "d-mmm-yy",
+	16: "d-mmm",
+	17: "mmm-yy",
+	18: "h:mm AM/PM",
+	19: "h:mm:ss AM/PM",
+	20: "h:mm",
+	21: "h:mm:ss",
+	22: "m/d/yyyy h:mm",
+	37: "#,##0",
+	38: "#,##0",
+	39: "#,##0.00",
+	40: "#,##0.00",
+	45: "mm:ss",
+	46: "h:mm:ss",
+	47: "mm:ss.0",
+}
+
+// cellKey identifies a single cell within a workbook by sheet, row and column; sheet is a 0-based
+// index into the worksheet BOF records in the order they appear, matching WorkBook.GetSheet(n).
+type cellKey struct {
+	sheet, row, col int
+}
+
+// CellFormat is the per-cell information FormattedCol needs beyond the cell's plain decoded text.
+type CellFormat struct {
+	NumberFormat  string // XF number-format code, e.g. "yyyy-mm-dd"; empty if General or unknown
+	HasFormula    bool   // true if the cell holds a FORMULA record
+	FormulaResult string // the formula's cached result, valid only when HasFormula is true
+}
+
+// CellFormats is a per-cell index built by BuildCellFormats.
+type CellFormats struct {
+	cells map[cellKey]CellFormat
+}
+
+// Get returns the CellFormat for (sheet, row, col), or the zero value (no number format, no cached
+// formula) if the cell wasn't seen while building the index, e.g. because the index could not be
+// built for this workbook.
+func (cf *CellFormats) Get(sheet, row, col int) CellFormat {
+	if cf == nil {
+		return CellFormat{}
+	}
+	return cf.cells[cellKey{sheet, row, col}]
+}
+
+// BuildCellFormats reads the workbook's raw BIFF8 stream from reader (which is left at an
+// unspecified position afterwards) and returns the per-cell number-format and cached-formula-result
+// index described above.
+func BuildCellFormats(reader io.ReadSeeker) (*CellFormats, error) {
+	if _, err := reader.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	fileBytes, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	cfb, err := parseCFB(fileBytes)
+	if err != nil {
+		return nil, err
+	}
+	stream, _, err := cfb.findStream("Workbook", "Book")
+	if err != nil {
+		return nil, err
+	}
+
+	b := &cellFormatBuilder{
+		cells:      make(map[cellKey]CellFormat),
+		customFmts: make(map[int]string),
+		sheetIndex: -1,
+	}
+	b.scan(stream)
+
+	return &CellFormats{cells: b.cells}, nil
+}
+
+// cellFormatBuilder holds the running state of a single sequential pass over a Workbook stream.
+type cellFormatBuilder struct {
+	cells      map[cellKey]CellFormat
+	customFmts map[int]string // ifmt -> format code, from FORMAT records
+	xfFormats  []string       // index -> format code, one entry per XF record in file order
+	sheetIndex int            // -1 until the first worksheet BOF is seen
+
+	pendingFormula *cellKey // set right after a FORMULA record whose result is a following STRING record
+}
+
+func (b *cellFormatBuilder) scan(stream []byte) {
+	pos := 0
+	for pos+4 <= len(stream) {
+		recID := binary.LittleEndian.Uint16(stream[pos:])
+		recLen := int(binary.LittleEndian.Uint16(stream[pos+2:]))
+		payloadStart := pos + 4
+		if payloadStart+recLen > len(stream) {
+			return
+		}
+		payload := stream[payloadStart : payloadStart+recLen]
+
+		switch recID {
+		case recBOF:
+			if len(payload) >= 4 && bofDocType(binary.LittleEndian.Uint16(payload[2:])) == bofDocTypeWorksheet {
+				b.sheetIndex++
+			}
+		case recFormat:
+			b.handleFormat(payload)
+		case recXF:
+			b.handleXF(payload)
+		case recString:
+			b.handleString(payload)
+		default:
+			if isCellValueRecord(recID) {
+				b.handleCellValue(recID, payload)
+			}
+		}
+
+		pos = payloadStart + recLen
+	}
+}
+
+func isCellValueRecord(id uint16) bool {
+	switch id {
+	case recNumber, recRK, recMulRK, recMulBlank, recLabelSST, recLabel, recFormula, recBlank, recBoolErr:
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *cellFormatBuilder) handleFormat(payload []byte) {
+	if len(payload) < 4 {
+		return
+	}
+	ifmt := int(binary.LittleEndian.Uint16(payload))
+	code, _ := readBiffUnicodeString(payload[2:])
+	if code != "" {
+		b.customFmts[ifmt] = code
+	}
+}
+
+func (b *cellFormatBuilder) handleXF(payload []byte) {
+	format := ""
+	if len(payload) >= 4 {
+		ifmt := int(binary.LittleEndian.Uint16(payload[2:]))
+		if code, ok := b.customFmts[ifmt]; ok {
+			format = code
+		} else if code, ok := builtinNumberFormats[ifmt]; ok {
+			format = code
+		}
+	}
+	b.xfFormats = append(b.xfFormats, format)
+}
+
+func (b *cellFormatBuilder) formatForXF(xf int) string {
+	if xf < 0 || xf >= len(b.xfFormats) {
+		return ""
+	}
+	return b.xfFormats[xf]
+}
+
+// handleString assigns the string result of the FORMULA record that immediately preceded it.
+func (b *cellFormatBuilder) handleString(payload []byte) {
+	if b.pendingFormula == nil {
+		return
+	}
+	s, _ := readBiffUnicodeString(payload)
+	cf := b.cells[*b.pendingFormula]
+	cf.FormulaResult = s
+	b.cells[*b.pendingFormula] = cf
+	b.pendingFormula = nil
+}
+
+func (b *cellFormatBuilder) handleCellValue(recID uint16, payload []byte) {
+	b.pendingFormula = nil
+
+	switch recID {
+	case recNumber:
+		if len(payload) < 14 {
+			return
+		}
+		row, col, xf := cellHeader(payload)
+		b.setCellFormat(row, col, xf)
+
+	case recRK:
+		if len(payload) < 10 {
+			return
+		}
+		row, col, xf := cellHeader(payload)
+		b.setCellFormat(row, col, xf)
+
+	case recMulRK:
+		if len(payload) < 6 {
+			return
+		}
+		row := int(binary.LittleEndian.Uint16(payload))
+		firstCol := int(binary.LittleEndian.Uint16(payload[2:]))
+		n := (len(payload) - 6) / 6
+		for i := 0; i < n; i++ {
+			xf := int(binary.LittleEndian.Uint16(payload[4+i*6:]))
+			b.setCellFormat(row, firstCol+i, xf)
+		}
+
+	case recMulBlank:
+		if len(payload) < 6 {
+			return
+		}
+		row := int(binary.LittleEndian.Uint16(payload))
+		firstCol := int(binary.LittleEndian.Uint16(payload[2:]))
+		n := (len(payload) - 6) / 2
+		for i := 0; i < n; i++ {
+			xf := int(binary.LittleEndian.Uint16(payload[4+i*2:]))
+			b.setCellFormat(row, firstCol+i, xf)
+		}
+
+	case recLabelSST, recLabel, recBlank, recBoolErr:
+		if len(payload) < 6 {
+			return
+		}
+		row, col, xf := cellHeader(payload)
+		b.setCellFormat(row, col, xf)
+
+	case recFormula:
+		if len(payload) < 14 {
+			return
+		}
+		row, col, xf := cellHeader(payload)
+		b.setCellFormat(row, col, xf)
+
+		key := cellKey{b.sheetIndex, row, col}
+		result := payload[6:14]
+		cf := b.cells[key]
+		cf.HasFormula = true
+
+		if binary.LittleEndian.Uint16(result) == 0xFFFF {
+			switch result[2] {
+			case 0x00:
+				// String result: the following STRING record carries the text.
+				b.cells[key] = cf
+				k := key
+				b.pendingFormula = &k
+				return
+			case 0x01:
+				if result[4] != 0 {
+					cf.FormulaResult = "TRUE"
+				} else {
+					cf.FormulaResult = "FALSE"
+				}
+			case 0x02:
+				cf.FormulaResult = ""
+			case 0x03:
+				cf.FormulaResult = ""
+			}
+		} else {
+			cf.FormulaResult = formatFloat(math.Float64frombits(binary.LittleEndian.Uint64(result)))
+		}
+		b.cells[key] = cf
+	}
+}
+
+func (b *cellFormatBuilder) setCellFormat(row, col, xf int) {
+	key := cellKey{b.sheetIndex, row, col}
+	cf := b.cells[key]
+	cf.NumberFormat = b.formatForXF(xf)
+	b.cells[key] = cf
+}
+
+// cellHeader reads the row(2)/col(2)/xf(2) header common to every single-cell value record.
+func cellHeader(payload []byte) (row, col, xf int) {
+	row = int(binary.LittleEndian.Uint16(payload))
+	col = int(binary.LittleEndian.Uint16(payload[2:]))
+	xf = int(binary.LittleEndian.Uint16(payload[4:]))
+	return
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// readBiffUnicodeString decodes a BIFF8 XLUnicodeString (cch uint16, grbit byte, then cch
+// characters, 1 or 2 bytes each depending on grbit's compressed-string bit) from the start of data,
+// returning the decoded text and the number of bytes consumed. Rich-text and Far East extra fields
+// (grbit bits 0x4/0x8) aren't present in FORMAT/STRING records, so they aren't handled here.
+func readBiffUnicodeString(data []byte) (string, int) {
+	if len(data) < 3 {
+		return "", 0
+	}
+	cch := int(binary.LittleEndian.Uint16(data))
+	grbit := data[2]
+	pos := 3
+
+	var buf bytes.Buffer
+	if grbit&0x1 == 0 {
+		if pos+cch > len(data) {
+			cch = len(data) - pos
+		}
+		for i := 0; i < cch; i++ {
+			buf.WriteByte(data[pos+i])
+		}
+		pos += cch
+	} else {
+		if pos+cch*2 > len(data) {
+			cch = (len(data) - pos) / 2
+		}
+		for i := 0; i < cch; i++ {
+			u := binary.LittleEndian.Uint16(data[pos+i*2:])
+			if u < 0x80 {
+				buf.WriteByte(byte(u))
+			} else {
+				buf.WriteRune(rune(u))
+			}
+		}
+		pos += cch * 2
+	}
+
+	return buf.String(), pos
+}
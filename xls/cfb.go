@@ -0,0 +1,212 @@
+/*
+File Name:  cfb.go
+Copyright:  2019 Kleissner Investments s.r.o.
+Author:     Peter Kleissner
+
+Minimal reader/patcher for the Compound File Binary (CFB/OLE2) container that holds the Workbook
+stream in a .xls file. It only implements what OpenReaderWithPassword needs: locating the Workbook
+stream's bytes, and later writing decrypted bytes back into the same sectors of the original file
+so the result can be handed to OpenReader as a normal (unencrypted-looking) .xls file.
+
+Only the regular FAT sector chain is supported; streams that would use the short-stream
+("ministream") allocation table are out of scope, since the Workbook stream is always well above
+the 4096-byte short-stream cutoff in practice.  Only the 109 DIFAT entries in the header are read,
+so files needing additional DIFAT sectors (more than ~109 FAT sectors, i.e. very large files) are
+not supported either.
+*/
+
+package xls
+
+import (
+	"encoding/binary"
+	"errors"
+	"unicode/utf16"
+)
+
+const (
+	cfbSectorEndOfChain = 0xFFFFFFFE
+
+	cfbHeaderSize      = 512
+	cfbDirEntrySize    = 128
+	cfbDifatEntryCount = 109
+)
+
+// ErrNotCFB is returned by parseCFB when the data doesn't start with the OLE2 signature.
+var ErrNotCFB = errors.New("xls: not a compound file (OLE2/CFB) document")
+
+// cfbFile is a parsed view over a CFB document's sector allocation, enough to find a named stream
+// and read or patch its bytes.
+type cfbFile struct {
+	data       []byte
+	sectorSize int
+	fat        []uint32
+	rootSector uint32
+}
+
+// parseCFB parses the CFB header, DIFAT and FAT of data, which must be the whole file.
+func parseCFB(data []byte) (*cfbFile, error) {
+	if len(data) < cfbHeaderSize {
+		return nil, ErrNotCFB
+	}
+	sig := []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+	for i, b := range sig {
+		if data[i] != b {
+			return nil, ErrNotCFB
+		}
+	}
+
+	sectorShift := binary.LittleEndian.Uint16(data[30:])
+	sectorSize := 1 << sectorShift
+	numFATSectors := int(binary.LittleEndian.Uint32(data[44:]))
+	firstDirSector := binary.LittleEndian.Uint32(data[48:])
+
+	if numFATSectors > cfbDifatEntryCount {
+		return nil, errors.New("xls: compound file has more FAT sectors than the header DIFAT can hold")
+	}
+
+	f := &cfbFile{data: data, sectorSize: sectorSize, rootSector: firstDirSector}
+
+	fatSectors := make([]uint32, numFATSectors)
+	for i := 0; i < numFATSectors; i++ {
+		fatSectors[i] = binary.LittleEndian.Uint32(data[76+i*4:])
+	}
+
+	entriesPerSector := sectorSize / 4
+	f.fat = make([]uint32, 0, numFATSectors*entriesPerSector)
+	for _, sec := range fatSectors {
+		off := f.sectorOffset(sec)
+		if off+sectorSize > len(data) {
+			return nil, errors.New("xls: FAT sector out of range")
+		}
+		for i := 0; i < entriesPerSector; i++ {
+			f.fat = append(f.fat, binary.LittleEndian.Uint32(data[off+i*4:]))
+		}
+	}
+
+	return f, nil
+}
+
+// sectorOffset returns the byte offset of sector id within the file (sector 0 immediately follows
+// the 512-byte header).
+func (f *cfbFile) sectorOffset(id uint32) int {
+	return cfbHeaderSize + int(id)*f.sectorSize
+}
+
+// readChain follows the FAT chain starting at sector id and returns the concatenated sector bytes,
+// truncated to size.
+func (f *cfbFile) readChain(id uint32, size int) ([]byte, error) {
+	out := make([]byte, 0, size)
+	for id != cfbSectorEndOfChain && len(out) < size {
+		off := f.sectorOffset(id)
+		if off+f.sectorSize > len(f.data) {
+			return nil, errors.New("xls: sector chain out of range")
+		}
+		out = append(out, f.data[off:off+f.sectorSize]...)
+
+		if int(id) >= len(f.fat) {
+			return nil, errors.New("xls: sector chain references a sector outside the FAT")
+		}
+		id = f.fat[id]
+	}
+	if len(out) > size {
+		out = out[:size]
+	}
+	return out, nil
+}
+
+// writeChain overwrites the sectors of the chain starting at id, in place within f.data, with
+// plaintext. len(plaintext) must not exceed the stream's original size (only legacy XOR/RC4 schemes are
+// supported, which never change a stream's length).
+func (f *cfbFile) writeChain(id uint32, plaintext []byte) error {
+	pos := 0
+	for id != cfbSectorEndOfChain && pos < len(plaintext) {
+		off := f.sectorOffset(id)
+		n := f.sectorSize
+		if pos+n > len(plaintext) {
+			n = len(plaintext) - pos
+		}
+		copy(f.data[off:off+n], plaintext[pos:pos+n])
+		pos += n
+
+		if int(id) >= len(f.fat) {
+			return errors.New("xls: sector chain references a sector outside the FAT")
+		}
+		id = f.fat[id]
+	}
+	return nil
+}
+
+// cfbDirEntry is the subset of a 128-byte directory entry we need to find and read a stream.
+type cfbDirEntry struct {
+	name        string
+	objectType  byte
+	startSector uint32
+	streamSize  int64
+}
+
+// directoryEntries reads every directory entry from the root directory's sector chain. The
+// directory stream's size isn't tracked by the header, so entries are read sector-by-sector until
+// end-of-chain; unused entries (object type 0) are skipped.
+func (f *cfbFile) directoryEntries() ([]cfbDirEntry, error) {
+	var entries []cfbDirEntry
+
+	id := f.rootSector
+	for id != cfbSectorEndOfChain {
+		off := f.sectorOffset(id)
+		if off+f.sectorSize > len(f.data) {
+			return nil, errors.New("xls: directory sector out of range")
+		}
+		sector := f.data[off : off+f.sectorSize]
+
+		for e := 0; e+cfbDirEntrySize <= len(sector); e += cfbDirEntrySize {
+			entry := sector[e : e+cfbDirEntrySize]
+			nameLen := int(binary.LittleEndian.Uint16(entry[64:]))
+			objectType := entry[66]
+			if objectType == 0 || nameLen < 2 {
+				continue
+			}
+
+			units := make([]uint16, 0, nameLen/2)
+			for i := 0; i+1 < nameLen-2; i += 2 {
+				units = append(units, binary.LittleEndian.Uint16(entry[i:]))
+			}
+			name := string(utf16.Decode(units))
+
+			entries = append(entries, cfbDirEntry{
+				name:        name,
+				objectType:  objectType,
+				startSector: binary.LittleEndian.Uint32(entry[116:]),
+				streamSize:  int64(binary.LittleEndian.Uint64(entry[120:])),
+			})
+		}
+
+		if int(id) >= len(f.fat) {
+			return nil, errors.New("xls: directory chain references a sector outside the FAT")
+		}
+		id = f.fat[id]
+	}
+
+	return entries, nil
+}
+
+// findStream returns the decoded bytes of the first stream directory entry whose name is one of
+// names (tried in order, e.g. "Workbook" then the pre-BIFF8 "Book"), along with the entry so its
+// sector chain can be patched later.
+func (f *cfbFile) findStream(names ...string) ([]byte, cfbDirEntry, error) {
+	entries, err := f.directoryEntries()
+	if err != nil {
+		return nil, cfbDirEntry{}, err
+	}
+
+	const objectTypeStream = 2
+	for _, want := range names {
+		for _, e := range entries {
+			if e.objectType == objectTypeStream && e.name == want {
+				data, err := f.readChain(e.startSector, int(e.streamSize))
+				return data, e, err
+			}
+		}
+	}
+
+	return nil, cfbDirEntry{}, errors.New("xls: Workbook stream not found")
+}
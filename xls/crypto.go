@@ -0,0 +1,362 @@
+/*
+File Name:  crypto.go
+Copyright:  2019 Kleissner Investments s.r.o.
+Author:     Peter Kleissner
+
+Decryption for the legacy password-protection schemes found in BIFF8 workbooks: Office 95 XOR
+obfuscation, 40-bit RC4 keyed from a password hash, and RC4 CryptoAPI ("strong encryption") with
+SHA-1 key derivation and AES-CBC block encryption. Detection and parsing of the FILEPASS record
+happen in password.go; this file covers key derivation, the block ciphers themselves, and the
+decryptingReader that re-keys every recordBlockSize bytes.
+*/
+
+package xls
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rc4"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"unicode/utf16"
+)
+
+// ErrPasswordRequired is returned by OpenReaderWithPassword when the workbook is encrypted but no
+// password was supplied.
+var ErrPasswordRequired = errors.New("xls: password required")
+
+// ErrWrongPassword is returned by OpenReaderWithPassword when the supplied password fails to
+// validate against the FILEPASS record's verifier.
+var ErrWrongPassword = errors.New("xls: wrong password")
+
+// encryptionScheme identifies which of the legacy schemes a FILEPASS record describes.
+type encryptionScheme int
+
+const (
+	schemeXOR encryptionScheme = iota
+	schemeRC4
+	schemeRC4CryptoAPI
+)
+
+// filePassInfo is the decoded content of a FILEPASS record needed to derive the decryption key.
+type filePassInfo struct {
+	scheme       encryptionScheme
+	salt         []byte // CryptoAPI only
+	verifier     []byte
+	verifierHash []byte
+	keyBits      int // CryptoAPI only, 0 means the default 40-bit RC4 key
+	blockSize    int // AES block size in bytes when the CryptoAPI scheme uses AES-CBC, 0 for RC4
+}
+
+// recordBlockSize is the size, in bytes, of an RC4 re-keying block as used by both the legacy and
+// CryptoAPI RC4 schemes.
+const recordBlockSize = 1024
+
+// utf16LEBytes encodes s as UTF-16LE, the password encoding used by every BIFF8 crypto scheme.
+func utf16LEBytes(s string) []byte {
+	units := utf16.Encode([]rune(s))
+	out := make([]byte, len(units)*2)
+	for i, u := range units {
+		binary.LittleEndian.PutUint16(out[i*2:], u)
+	}
+	return out
+}
+
+// xorObfuscationKey derives the 16-byte Office 95 XOR obfuscation key from password.
+func xorObfuscationKey(password string) []byte {
+	pwBytes := utf16LEBytes(password)
+	key := make([]byte, 16)
+	for i := range key {
+		key[i] = pwBytes[i%len(pwBytes)] ^ byte(i)
+	}
+	return key
+}
+
+// xorDecrypt reverses Office 95 XOR obfuscation over data, where streamOffset is the byte offset
+// of data[0] within the workbook stream (obfuscation repeats on a 16-byte cycle anchored at the
+// start of the stream, not at the start of each record).
+func xorDecrypt(data []byte, key []byte, streamOffset int) []byte {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		out[i] = b ^ key[(streamOffset+i)%len(key)]
+	}
+	return out
+}
+
+// deriveRC4BlockKey derives the 40-bit RC4 key for block blockIndex of the legacy RC4 scheme:
+// MD5(salt || password) is truncated to 5 bytes, then re-hashed together with the little-endian
+// block index and truncated again, per the record re-keying used every 1024 bytes.
+func deriveRC4BlockKey(password string, salt []byte, blockIndex uint32) []byte {
+	h0 := md5.Sum(append(append([]byte{}, salt...), utf16LEBytes(password)...))
+	truncated := h0[:5]
+
+	buf := make([]byte, 9)
+	copy(buf, truncated)
+	binary.LittleEndian.PutUint32(buf[5:], blockIndex)
+
+	full := md5.Sum(buf)
+	return full[:5]
+}
+
+// deriveCryptoAPIKey derives the RC4 CryptoAPI / strong-encryption key for block blockIndex,
+// following the iterated SHA-1 derivation (50,000 rounds) and then the 0x36/0x5C padded expansion,
+// whose two SHA-1 digests are concatenated and truncated to keyBytes (at most 40 bytes).
+func deriveCryptoAPIKey(password string, salt []byte, blockIndex uint32, keyBytes int) []byte {
+	h := sha1.Sum(append(append([]byte{}, salt...), utf16LEBytes(password)...))
+	current := h[:]
+
+	for i := uint32(0); i < 50000; i++ {
+		buf := make([]byte, 4+len(current))
+		binary.LittleEndian.PutUint32(buf, i)
+		copy(buf[4:], current)
+		sum := sha1.Sum(buf)
+		current = sum[:]
+	}
+
+	finalBuf := make([]byte, len(current)+4)
+	copy(finalBuf, current)
+	binary.LittleEndian.PutUint32(finalBuf[len(current):], blockIndex)
+	final := sha1.Sum(finalBuf)
+
+	pad := func(b byte) []byte {
+		block := make([]byte, 64)
+		copy(block, final[:])
+		for i := len(final); i < len(block); i++ {
+			block[i] = b
+		}
+		return block
+	}
+
+	x1 := sha1.Sum(pad(0x36))
+	x2 := sha1.Sum(pad(0x5C))
+	key := append(append([]byte{}, x1[:]...), x2[:]...)
+
+	if keyBytes > len(key) {
+		keyBytes = len(key)
+	}
+	return key[:keyBytes]
+}
+
+// decryptingReader decrypts a workbook stream's record bytes into new slices, re-keying
+// every recordBlockSize bytes as BIFF8's legacy RC4 schemes require.
+type decryptingReader struct {
+	password string
+	info     filePassInfo
+	position int64
+}
+
+func newDecryptingReader(password string, info filePassInfo) *decryptingReader {
+	return &decryptingReader{password: password, info: info}
+}
+
+// Decrypt decrypts data, which starts at absolute stream offset, and advances the reader's
+// position. Callers are expected to call it once per contiguous, in-order read.
+func (d *decryptingReader) Decrypt(data []byte) ([]byte, error) {
+	switch d.info.scheme {
+	case schemeXOR:
+		out := xorDecrypt(data, xorObfuscationKey(d.password), int(d.position))
+		d.position += int64(len(data))
+		return out, nil
+
+	case schemeRC4:
+		out, err := d.decryptRC4(data, func(password string, blockIndex uint32) []byte {
+			return deriveRC4BlockKey(password, d.info.salt, blockIndex)
+		})
+		return out, err
+
+	case schemeRC4CryptoAPI:
+		if d.info.blockSize > 0 {
+			return d.decryptAESCBC(data)
+		}
+		keyBytes := d.info.keyBits / 8
+		if keyBytes == 0 {
+			keyBytes = 40 / 8
+		}
+		out, err := d.decryptRC4(data, func(password string, blockIndex uint32) []byte {
+			return deriveCryptoAPIKey(password, d.info.salt, blockIndex, keyBytes)
+		})
+		return out, err
+
+	default:
+		return nil, errors.New("xls: unsupported encryption scheme")
+	}
+}
+
+func (d *decryptingReader) decryptRC4(data []byte, keyFor func(password string, blockIndex uint32) []byte) ([]byte, error) {
+	out := make([]byte, 0, len(data))
+
+	for len(data) > 0 {
+		blockIndex := uint32(d.position / recordBlockSize)
+		offsetInBlock := int(d.position % recordBlockSize)
+
+		n := recordBlockSize - offsetInBlock
+		if n > len(data) {
+			n = len(data)
+		}
+
+		cipherObj, err := rc4.NewCipher(keyFor(d.password, blockIndex))
+		if err != nil {
+			return nil, err
+		}
+
+		// Advance the keystream to offsetInBlock, then decrypt this chunk of the block.
+		discard := make([]byte, offsetInBlock)
+		cipherObj.XORKeyStream(discard, discard)
+
+		chunk := make([]byte, n)
+		cipherObj.XORKeyStream(chunk, data[:n])
+
+		out = append(out, chunk...)
+		d.position += int64(n)
+		data = data[n:]
+	}
+
+	return out, nil
+}
+
+// decryptAESCBC decrypts data using the CryptoAPI "strong encryption" AES-CBC scheme, re-deriving
+// the key and restarting the CBC chain every recordBlockSize bytes, same as the RC4 schemes.
+func (d *decryptingReader) decryptAESCBC(data []byte) ([]byte, error) {
+	out := make([]byte, 0, len(data))
+
+	for len(data) > 0 {
+		blockIndex := uint32(d.position / recordBlockSize)
+		offsetInBlock := int(d.position % recordBlockSize)
+
+		n := recordBlockSize - offsetInBlock
+		if n > len(data) {
+			n = len(data)
+		}
+		if n%aes.BlockSize != 0 {
+			return nil, errors.New("xls: ciphertext chunk is not a multiple of the AES block size")
+		}
+
+		key := deriveCryptoAPIKey(d.password, d.info.salt, blockIndex, d.info.keyBits/8)
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+
+		iv := make([]byte, block.BlockSize())
+		copy(iv, d.info.salt)
+
+		chunk := make([]byte, n)
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(chunk, data[:n])
+
+		out = append(out, chunk...)
+		d.position += int64(n)
+		data = data[n:]
+	}
+
+	return out, nil
+}
+
+// verifyPassword checks password against the FILEPASS record's stored verifier and returns
+// ErrWrongPassword when it doesn't match.
+func verifyPassword(password string, info filePassInfo) error {
+	if len(info.verifier) == 0 || len(info.verifierHash) == 0 {
+		// No verifier to check against (shouldn't happen for a well-formed FILEPASS record);
+		// let the caller find out from whether the decrypted BIFF records parse.
+		return nil
+	}
+
+	if info.scheme == schemeXOR {
+		key, verifier := xorPasswordKeyAndVerifier(password)
+		var keyBuf, verifierBuf [2]byte
+		binary.LittleEndian.PutUint16(keyBuf[:], key)
+		binary.LittleEndian.PutUint16(verifierBuf[:], verifier)
+		if string(keyBuf[:]) != string(info.verifier) || string(verifierBuf[:]) != string(info.verifierHash) {
+			return ErrWrongPassword
+		}
+		return nil
+	}
+
+	// RC4 and RC4 CryptoAPI store a 16-byte EncryptedVerifier followed immediately by
+	// EncryptedVerifierHash in the same keystream, so both must be decrypted together with one
+	// continuing reader before comparing MD5 (RC4) or SHA-1 (CryptoAPI) of the verifier against
+	// the decrypted hash.
+	reader := newDecryptingReader(password, info)
+	decryptedVerifier, err := reader.Decrypt(info.verifier)
+	if err != nil {
+		return err
+	}
+	decryptedHash, err := reader.Decrypt(info.verifierHash)
+	if err != nil {
+		return err
+	}
+
+	if info.scheme == schemeRC4CryptoAPI {
+		sum := sha1.Sum(decryptedVerifier)
+		if string(sum[:len(decryptedHash)]) != string(decryptedHash) {
+			return ErrWrongPassword
+		}
+		return nil
+	}
+
+	hash := md5.Sum(decryptedVerifier)
+	if string(hash[:len(decryptedHash)]) != string(decryptedHash) {
+		return ErrWrongPassword
+	}
+	return nil
+}
+
+// xorInitialCode and xorEncryptionMatrix are the constant tables specified by MS-OFFCRYPTO
+// 2.3.7.1 "Binary Document Password Verifier Derivation Method 1", used by the legacy Excel 95
+// XOR obfuscation scheme to turn a password into the 16-bit Key/Verifier pair stored in FILEPASS.
+var xorInitialCode = [15]uint16{
+	0xE1F0, 0x1D0F, 0xCC9C, 0x84C0, 0x110C, 0x0E10, 0xF1CE, 0x313E,
+	0x1872, 0xE139, 0xD40F, 0x84F9, 0x280C, 0xA96A, 0x4EC3,
+}
+
+var xorEncryptionMatrix = [15][7]uint16{
+	{0xAEFC, 0x4DD9, 0x9BB2, 0x2745, 0x4E8A, 0x9D14, 0x2A09},
+	{0x7B61, 0xF6C2, 0xFDA5, 0xEB6B, 0xC6F7, 0x9DCF, 0x2BBF},
+	{0x4563, 0x8AC6, 0x05AD, 0x0B5A, 0x16B4, 0x2D68, 0x5AD0},
+	{0x0375, 0x06EA, 0x0DD4, 0x1BA8, 0x3750, 0x6EA0, 0xDD40},
+	{0xD849, 0xA0B3, 0x5147, 0xA28E, 0x553D, 0xAA7A, 0x44D5},
+	{0x6F45, 0xDE8A, 0xAD35, 0x4A4B, 0x9496, 0x390D, 0x721A},
+	{0xEB23, 0xC667, 0x9CCF, 0x15DF, 0x2BBE, 0x5773, 0xAEE6},
+	{0x1EF3, 0x3DE6, 0x7BCC, 0xF789, 0xA1A3, 0x3677, 0x6EEE},
+	{0x7A79, 0xFAE2, 0xEFC5, 0xDBAB, 0xBF27, 0x9F6F, 0x3FED},
+	{0xC92B, 0x926F, 0xB52F, 0xFA03, 0xEEF9, 0xDDE7, 0xC0D5},
+	{0xA3B0, 0x4F7D, 0x9EFA, 0xDCC5, 0xB1FB, 0x620D, 0xC42A},
+	{0x64FE, 0xC9FC, 0xA42B, 0x4917, 0x924E, 0x29BD, 0x537A},
+	{0x1D81, 0x3B00, 0x76FF, 0xFBAA, 0xF5F5, 0xF1EF, 0xE9D7},
+	{0xCCA3, 0x9987, 0x3AEF, 0xA7B9, 0x5DB7, 0x9BC5, 0x3A4E},
+	{0x8BC7, 0x07E5, 0x0F1B, 0x1EAA, 0x3BB2, 0x6F65, 0xDBE9},
+}
+
+// xorPasswordKeyAndVerifier derives the 16-bit obfuscation Key and password Verifier stored in a
+// FILEPASS record with wEncryptionType 0x0000 (Office 95 XOR obfuscation), per MS-OFFCRYPTO
+// 2.3.7.1. password is limited to the first 15 bytes, matching the table's dimensions.
+func xorPasswordKeyAndVerifier(password string) (key uint16, verifier uint16) {
+	pw := []byte(password)
+	if len(pw) > 15 {
+		pw = pw[:15]
+	}
+	if len(pw) == 0 {
+		return 0, 0
+	}
+
+	key = xorInitialCode[len(pw)-1]
+	for i := len(pw) - 1; i >= 0; i-- {
+		c := pw[i]
+		for bit := 0; bit < 7; bit++ {
+			if c&(1<<uint(bit)) != 0 {
+				key ^= xorEncryptionMatrix[i][bit]
+			}
+		}
+	}
+
+	for i := len(pw) - 1; i >= 0; i-- {
+		verifier = ((verifier >> 14) | (verifier << 1)) & 0x7FFF
+		verifier ^= uint16(pw[i])
+	}
+	verifier = ((verifier >> 14) | (verifier << 1)) & 0x7FFF
+	verifier ^= uint16(len(pw))
+	verifier ^= 0xCE4B
+
+	return key, verifier
+}
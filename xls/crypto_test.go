@@ -0,0 +1,133 @@
+/*
+File Name:  crypto_test.go
+Copyright:  2019 Kleissner Investments s.r.o.
+Author:     Peter Kleissner
+*/
+
+package xls
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"testing"
+)
+
+// encryptForTest runs plaintext through the same (symmetric) RC4/CryptoAPI keystream used to
+// decrypt, simulating what an encrypted FILEPASS verifier/hash would contain on disk. verifier and
+// verifierHash are encrypted back-to-back on one continuing reader, matching how verifyPassword
+// decrypts them.
+func encryptForTest(t *testing.T, password string, info filePassInfo, verifier, hash []byte) (encVerifier, encHash []byte) {
+	t.Helper()
+	reader := newDecryptingReader(password, info)
+	encVerifier, err := reader.Decrypt(verifier)
+	if err != nil {
+		t.Fatalf("encryptForTest verifier: %v", err)
+	}
+	encHash, err = reader.Decrypt(hash)
+	if err != nil {
+		t.Fatalf("encryptForTest hash: %v", err)
+	}
+	return encVerifier, encHash
+}
+
+func TestVerifyPasswordRC4(t *testing.T) {
+	salt := make([]byte, 16)
+	for i := range salt {
+		salt[i] = byte(i)
+	}
+
+	verifier := make([]byte, 16)
+	for i := range verifier {
+		verifier[i] = byte(0xAA + i)
+	}
+	hash := md5.Sum(verifier)
+
+	info := filePassInfo{scheme: schemeRC4, salt: salt}
+	info.verifier, info.verifierHash = encryptForTest(t, "correct horse", info, verifier, hash[:])
+
+	if err := verifyPassword("correct horse", info); err != nil {
+		t.Errorf("verifyPassword with correct password: %v", err)
+	}
+	if err := verifyPassword("wrong password", info); err != ErrWrongPassword {
+		t.Errorf("verifyPassword with wrong password = %v, want ErrWrongPassword", err)
+	}
+}
+
+func TestVerifyPasswordRC4CryptoAPI(t *testing.T) {
+	salt := make([]byte, 16)
+	for i := range salt {
+		salt[i] = byte(i * 3)
+	}
+
+	verifier := make([]byte, 16)
+	for i := range verifier {
+		verifier[i] = byte(0x10 + i)
+	}
+	sum := sha1.Sum(verifier)
+
+	info := filePassInfo{scheme: schemeRC4CryptoAPI, salt: salt, keyBits: 128}
+	info.verifier, info.verifierHash = encryptForTest(t, "s3cret", info, verifier, sum[:])
+
+	if err := verifyPassword("s3cret", info); err != nil {
+		t.Errorf("verifyPassword with correct password: %v", err)
+	}
+	if err := verifyPassword("nope", info); err != ErrWrongPassword {
+		t.Errorf("verifyPassword with wrong password = %v, want ErrWrongPassword", err)
+	}
+}
+
+// TestVerifyPasswordRC4KnownAnswer checks verifyPassword against ciphertext produced by an
+// independent, from-scratch RC4 + MD5 implementation (not this package's decryptingReader), so a
+// regression in deriveRC4BlockKey or decryptRC4 that's wrong in a way that still round-trips with
+// itself (the failure mode encryptForTest above can't catch) shows up as a verification failure.
+func TestVerifyPasswordRC4KnownAnswer(t *testing.T) {
+	salt := make([]byte, 16)
+	for i := range salt {
+		salt[i] = byte(i)
+	}
+
+	info := filePassInfo{
+		scheme: schemeRC4,
+		salt:   salt,
+		verifier: []byte{
+			0xfe, 0x36, 0x9f, 0x1d, 0x80, 0x42, 0xb1, 0x94,
+			0x4b, 0x25, 0x6d, 0xef, 0x1d, 0xfc, 0xb1, 0x73,
+		},
+		verifierHash: []byte{
+			0x05, 0x36, 0xda, 0xc8, 0x61, 0xc0, 0x99, 0xa2,
+			0x88, 0xd3, 0x88, 0x94, 0x94, 0x05, 0xab, 0xcd,
+		},
+	}
+
+	if err := verifyPassword("Secret1", info); err != nil {
+		t.Errorf("verifyPassword with correct password: %v", err)
+	}
+	if err := verifyPassword("wrong", info); err != ErrWrongPassword {
+		t.Errorf("verifyPassword with wrong password = %v, want ErrWrongPassword", err)
+	}
+}
+
+func TestDeriveRC4BlockKeyUsesSalt(t *testing.T) {
+	saltA := []byte("0123456789ABCDEF")
+	saltB := []byte("FEDCBA9876543210")
+
+	keyA := deriveRC4BlockKey("password", saltA, 0)
+	keyB := deriveRC4BlockKey("password", saltB, 0)
+
+	if string(keyA) == string(keyB) {
+		t.Error("deriveRC4BlockKey produced the same key for two different salts")
+	}
+}
+
+func TestXorPasswordKeyAndVerifierDeterministic(t *testing.T) {
+	key1, verifier1 := xorPasswordKeyAndVerifier("hunter2")
+	key2, verifier2 := xorPasswordKeyAndVerifier("hunter2")
+	if key1 != key2 || verifier1 != verifier2 {
+		t.Error("xorPasswordKeyAndVerifier is not deterministic")
+	}
+
+	key3, verifier3 := xorPasswordKeyAndVerifier("different")
+	if key1 == key3 && verifier1 == verifier3 {
+		t.Error("xorPasswordKeyAndVerifier produced identical output for different passwords")
+	}
+}
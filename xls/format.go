@@ -0,0 +1,32 @@
+/*
+File Name:  format.go
+Copyright:  2019 Kleissner Investments s.r.o.
+Author:     Peter Kleissner
+
+Wires the XF number-format code and cached FORMULA result (both extracted by BuildCellFormats in
+cellformat.go) into Row, so callers can read a cell's displayed value instead of its raw stored
+string.
+*/
+
+package xls
+
+// TextOptions controls how FormattedCol renders a cell.
+type TextOptions struct {
+	RenderFormulas bool   // show a formula cell's cached result (cf.FormulaResult) instead of its raw text
+	DateFormat     string // Go time layout used for date cells instead of the one derived from their XF format code; empty uses the derived layout
+	Locale         string // reserved for locale-specific number formatting, currently unused
+}
+
+// FormattedCol returns column c of the row rendered the way Excel would display it: if the cell is
+// a formula and opts.RenderFormulas is set, its cached result (cf.FormulaResult, as extracted by
+// BuildCellFormats) is shown instead of the raw stored text; either way the result is then rendered
+// through the cell's XF number-format code (cf.NumberFormat, e.g. "yyyy-mm-dd" or "$#,##0.00").
+func (row *Row) FormattedCol(c int, cf CellFormat, opts TextOptions) string {
+	text := row.Col(c)
+
+	if opts.RenderFormulas && cf.HasFormula {
+		text = cf.FormulaResult
+	}
+
+	return applyNumberFormatWithDateLayout(text, cf.NumberFormat, opts.DateFormat)
+}
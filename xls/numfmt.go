@@ -0,0 +1,138 @@
+/*
+File Name:  numfmt.go
+Copyright:  2019 Kleissner Investments s.r.o.
+Author:     Peter Kleissner
+
+Renders a raw numeric cell value according to an XF number-format code, so dates come out as
+2024-03-15 instead of a serial number and currencies come out as $1,234.56 instead of a float.
+Covers the common built-in BIFF number formats rather than the full format-code grammar.
+*/
+
+package xls
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// excelEpoch is day 0 of the 1900 date system used by BIFF8 serial dates.
+var excelEpoch = time.Date(1899, time.December, 30, 0, 0, 0, 0, time.UTC)
+
+// ApplyNumberFormat renders value (the raw stored cell text) according to format, a BIFF/XF
+// number-format code such as "yyyy-mm-dd" or "$#,##0.00". Unrecognized or empty formats pass
+// value through unchanged.
+func ApplyNumberFormat(value, format string) string {
+	return applyNumberFormat(value, format, "")
+}
+
+// applyNumberFormatWithDateLayout is like ApplyNumberFormat, but renders date cells with
+// dateLayout (a Go reference layout) instead of deriving one from format.
+func applyNumberFormatWithDateLayout(value, format, dateLayout string) string {
+	return applyNumberFormat(value, format, dateLayout)
+}
+
+func applyNumberFormat(value, format, dateLayout string) string {
+	format = strings.TrimSpace(format)
+	if format == "" || strings.EqualFold(format, "General") {
+		return value
+	}
+
+	n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return value
+	}
+
+	if isDateFormat(format) {
+		layout := dateLayout
+		if layout == "" {
+			layout = goDateLayout(format)
+		}
+		return excelEpoch.AddDate(0, 0, int(n)).Format(layout)
+	}
+
+	if isCurrencyFormat(format) {
+		return fmt.Sprintf("$%s", commaSeparate(n, decimalsInFormat(format)))
+	}
+
+	if strings.Contains(format, "%") {
+		return fmt.Sprintf("%s%%", strconv.FormatFloat(n*100, 'f', decimalsInFormat(format), 64))
+	}
+
+	if strings.Contains(format, ",") {
+		return commaSeparate(n, decimalsInFormat(format))
+	}
+
+	if decimals := decimalsInFormat(format); decimals > 0 {
+		return strconv.FormatFloat(n, 'f', decimals, 64)
+	}
+
+	return value
+}
+
+func isDateFormat(format string) bool {
+	f := strings.ToLower(format)
+	return strings.ContainsAny(f, "ymd") && !strings.ContainsAny(f, "#0")
+}
+
+func isCurrencyFormat(format string) bool {
+	return strings.ContainsAny(format, "$€£¥")
+}
+
+// decimalsInFormat counts digits after the last '.' in the format's numeric placeholders.
+func decimalsInFormat(format string) int {
+	idx := strings.LastIndex(format, ".")
+	if idx == -1 {
+		return 0
+	}
+	n := 0
+	for i := idx + 1; i < len(format) && (format[i] == '0' || format[i] == '#'); i++ {
+		n++
+	}
+	return n
+}
+
+func commaSeparate(n float64, decimals int) string {
+	s := strconv.FormatFloat(n, 'f', decimals, 64)
+	neg := strings.HasPrefix(s, "-")
+	if neg {
+		s = s[1:]
+	}
+
+	intPart, fracPart, _ := strings.Cut(s, ".")
+
+	var grouped strings.Builder
+	for i, digit := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			grouped.WriteByte(',')
+		}
+		grouped.WriteRune(digit)
+	}
+
+	out := grouped.String()
+	if fracPart != "" {
+		out += "." + fracPart
+	}
+	if neg {
+		out = "-" + out
+	}
+	return out
+}
+
+// goDateLayout converts the common BIFF date tokens (yyyy, mm, dd, ...) to a Go reference layout.
+func goDateLayout(format string) string {
+	replacer := strings.NewReplacer(
+		"yyyy", "2006",
+		"yy", "06",
+		"mmmm", "January",
+		"mmm", "Jan",
+		"mm", "01",
+		"m", "1",
+		"dd", "02",
+		"d", "2",
+		"hh", "15",
+		"ss", "05",
+	)
+	return replacer.Replace(strings.ToLower(format))
+}
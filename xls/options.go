@@ -0,0 +1,99 @@
+/*
+File Name:  options.go
+Copyright:  2019 Kleissner Investments s.r.o.
+Author:     Peter Kleissner
+
+Resource limits for parsing untrusted workbooks, following the same idea as excelize's
+UnzipSizeLimit change for OOXML files. MaxDecompressedBytes and MaxSheets are enforced here, around
+OpenReader itself. MaxCellCount, MaxRowsPerSheet and CellValueMaxLen can only be enforced once cells
+are decoded into rows and columns, which OpenReader doesn't expose a hook into; xls2txt's
+XLS2TextWithOptions, XLS2CellsWithOptions and XLS2CSVWithOptions enforce those three while walking
+the parsed WorkBook.
+
+An earlier version of this file tried to enforce MaxCellCount/MaxRowsPerSheet/CellValueMaxLen here
+too, by scanning raw BIFF record headers (2-byte ID + 2-byte length) out of the bytes read through
+boundedReadSeeker. That doesn't work: OpenReader reads the underlying CFB container in a
+seek-driven, non-sequential order (FAT sectors, directory sectors, and stream sector chains that
+aren't contiguous), not a clean in-stream-order BIFF record stream, so a record-framing scan sees
+garbage IDs/lengths. It was removed in favor of the decoded-layer checks, which see real rows and
+cells and can't be fooled by sector ordering.
+*/
+
+package xls
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrLimitExceeded is returned once the MaxCellCount, MaxDecompressedBytes or MaxSheets threshold is
+// hit while parsing a workbook. Rows past MaxRowsPerSheet are skipped and cell text longer than
+// CellValueMaxLen is truncated; neither returns an error.
+var ErrLimitExceeded = errors.New("xls: parsing limit exceeded")
+
+// Options bounds the resources OpenReaderWithOptions is willing to spend on a single workbook,
+// so a maliciously crafted .xls file can't balloon memory or CPU use.
+type Options struct {
+	MaxCellCount         int   // maximum number of non-empty cells across the whole workbook, 0 = unlimited
+	MaxDecompressedBytes int64 // maximum cumulative bytes read back out of the underlying stream while parsing, 0 = unlimited
+	MaxSheets            int   // maximum number of sheets, 0 = unlimited
+	MaxRowsPerSheet      int   // maximum rows read per sheet, 0 = unlimited
+	CellValueMaxLen      int   // maximum length of a single cell's stored string, 0 = unlimited
+}
+
+// DefaultOptions returns conservative limits suitable for parsing files from untrusted sources.
+func DefaultOptions() Options {
+	return Options{
+		MaxCellCount:         5_000_000,
+		MaxDecompressedBytes: 512 * 1024 * 1024,
+		MaxSheets:            256,
+		MaxRowsPerSheet:      1 << 20,
+		CellValueMaxLen:      32 * 1024,
+	}
+}
+
+// OpenReaderWithOptions is identical to OpenReader, except reads performed while walking the
+// workbook's CFB streams are bounded by opts.MaxDecompressedBytes, catching sector chains crafted
+// to make the CFB reader revisit the same bytes endlessly, and the sheet count is bounded by
+// opts.MaxSheets. It returns ErrLimitExceeded as soon as either threshold is hit. See the package
+// comment above for why MaxCellCount, MaxRowsPerSheet and CellValueMaxLen aren't enforced here.
+func OpenReaderWithOptions(reader io.ReadSeeker, charset string, opts Options) (*WorkBook, error) {
+	bounded := &boundedReadSeeker{ReadSeeker: reader, maxBytes: opts.MaxDecompressedBytes}
+
+	wb, err := OpenReader(bounded, charset)
+	if err != nil {
+		if bounded.exceeded {
+			return nil, ErrLimitExceeded
+		}
+		return nil, err
+	}
+
+	if opts.MaxSheets > 0 && wb.NumSheets() > opts.MaxSheets {
+		return nil, ErrLimitExceeded
+	}
+
+	return wb, nil
+}
+
+// boundedReadSeeker wraps a ReadSeeker and fails once more than maxBytes have been read through
+// it, catching sector chains crafted to make the CFB reader revisit the same bytes endlessly.
+type boundedReadSeeker struct {
+	io.ReadSeeker
+	maxBytes int64
+	read     int64
+	exceeded bool
+}
+
+func (b *boundedReadSeeker) Read(p []byte) (int, error) {
+	n, err := b.ReadSeeker.Read(p)
+
+	if b.maxBytes > 0 {
+		b.read += int64(n)
+		if b.read > b.maxBytes {
+			b.exceeded = true
+			return n, ErrLimitExceeded
+		}
+	}
+
+	return n, err
+}
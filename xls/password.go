@@ -0,0 +1,244 @@
+/*
+File Name:  password.go
+Copyright:  2019 Kleissner Investments s.r.o.
+Author:     Peter Kleissner
+
+OpenReaderWithPassword detects the FILEPASS record in the Workbook stream and, if the workbook is
+encrypted, decrypts it with one of the schemes in crypto.go before handing the plaintext BIFF
+records to the normal parser. Locating and patching the Workbook stream within the file's CFB/OLE2
+container is handled by cfb.go.
+*/
+
+package xls
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// OpenReaderWithPassword is identical to OpenReader, except it detects a FILEPASS record in the
+// Workbook stream and decrypts the workbook before parsing it. password may be empty for
+// unencrypted files; OpenReader itself never needs to be password-aware.
+func OpenReaderWithPassword(reader io.ReadSeeker, charset, password string) (*WorkBook, error) {
+	if _, err := reader.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	fileBytes, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	cfb, err := parseCFB(fileBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	streamData, streamEntry, err := cfb.findStream("Workbook", "Book")
+	if err != nil {
+		return nil, err
+	}
+
+	info, encrypted, err := findFilePassRecord(streamData)
+	if err != nil {
+		return nil, err
+	}
+	if !encrypted {
+		return OpenReader(bytes.NewReader(fileBytes), charset)
+	}
+	if password == "" {
+		return nil, ErrPasswordRequired
+	}
+	if err := verifyPassword(password, info); err != nil {
+		return nil, err
+	}
+
+	plaintext, err := decryptWorkbookStream(streamData, password, info)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cfb.writeChain(streamEntry.startSector, plaintext); err != nil {
+		return nil, err
+	}
+
+	return OpenReader(bytes.NewReader(cfb.data), charset)
+}
+
+// filePassRecordID is the BIFF record id (0x002F) of the FILEPASS record.
+const filePassRecordID = 0x002F
+
+// findFilePassRecord walks the top-level BIFF records of a Workbook stream looking for FILEPASS.
+// It stops at the first record other than BOF, since FILEPASS always immediately
+// follows the workbook's initial BOF record.
+func findFilePassRecord(stream []byte) (info filePassInfo, encrypted bool, err error) {
+	pos := 0
+	for pos+4 <= len(stream) {
+		recID := binary.LittleEndian.Uint16(stream[pos:])
+		recLen := int(binary.LittleEndian.Uint16(stream[pos+2:]))
+		payloadStart := pos + 4
+		if payloadStart+recLen > len(stream) {
+			break
+		}
+		payload := stream[payloadStart : payloadStart+recLen]
+
+		if recID == filePassRecordID {
+			info, err = parseFilePassRecord(payload)
+			return info, true, err
+		}
+
+		// BOF (0x0809) must come first; anything else before FILEPASS means the file isn't encrypted.
+		if recID != 0x0809 {
+			return filePassInfo{}, false, nil
+		}
+
+		pos = payloadStart + recLen
+	}
+
+	return filePassInfo{}, false, nil
+}
+
+// parseFilePassRecord decodes a FILEPASS record payload per MS-OFFCRYPTO. XOR obfuscation stores
+// a 2-byte key and 2-byte hash; RC4 and RC4 CryptoAPI store a version tag followed by a
+// scheme-specific header.
+func parseFilePassRecord(payload []byte) (filePassInfo, error) {
+	if len(payload) < 2 {
+		return filePassInfo{}, io.ErrUnexpectedEOF
+	}
+
+	wEncryptionType := binary.LittleEndian.Uint16(payload)
+	if wEncryptionType == 0x0000 {
+		if len(payload) < 6 {
+			return filePassInfo{}, io.ErrUnexpectedEOF
+		}
+		return filePassInfo{
+			scheme:       schemeXOR,
+			verifier:     payload[2:4],
+			verifierHash: payload[4:6],
+		}, nil
+	}
+
+	// wEncryptionType == 0x0001: either legacy RC4 or RC4 CryptoAPI, distinguished by vMajor.
+	if len(payload) < 4 {
+		return filePassInfo{}, io.ErrUnexpectedEOF
+	}
+	vMajor := binary.LittleEndian.Uint16(payload[2:])
+
+	if vMajor == 1 {
+		// Legacy RC4: vMinor(2) + Salt(16) + EncryptedVerifier(16) + EncryptedVerifierHash(16).
+		const headerLen = 6 // wEncryptionType(2) + vMajor(2) + vMinor(2)
+		if len(payload) < headerLen+16+16+16 {
+			return filePassInfo{}, io.ErrUnexpectedEOF
+		}
+		salt := payload[headerLen : headerLen+16]
+		verifier := payload[headerLen+16 : headerLen+32]
+		verifierHash := payload[headerLen+32 : headerLen+48]
+		return filePassInfo{
+			scheme:       schemeRC4,
+			salt:         salt,
+			verifier:     verifier,
+			verifierHash: verifierHash,
+		}, nil
+	}
+
+	// RC4 CryptoAPI ("strong encryption"): wEncryptionType(2) + vMajor(2), already consumed above,
+	// are followed by vMinor(2) + Flags(4) + EncryptionHeaderSize(4), then a variable-length
+	// EncryptionHeader (8 DWORDs plus a null-terminated CSPName string, per [MS-OFFCRYPTO]
+	// 2.3.4.5/2.3.4.6) and an EncryptionVerifier whose own SaltSize field gives the real salt length
+	// (2.3.4.7). EncryptionHeaderSize is what locates the verifier, so the CSPName string never needs
+	// to be decoded, only skipped over.
+	const headerSizeOffset = 10 // wEncryptionType(2) + vMajor(2) + vMinor(2) + Flags(4)
+	const headerStart = headerSizeOffset + 4
+	if len(payload) < headerStart {
+		return filePassInfo{}, io.ErrUnexpectedEOF
+	}
+
+	encryptionHeaderSize := int(binary.LittleEndian.Uint32(payload[headerSizeOffset:]))
+	if encryptionHeaderSize < 20 || headerStart+encryptionHeaderSize > len(payload) {
+		return filePassInfo{}, io.ErrUnexpectedEOF
+	}
+	header := payload[headerStart : headerStart+encryptionHeaderSize]
+
+	algID := binary.LittleEndian.Uint32(header[8:])
+	keySize := binary.LittleEndian.Uint32(header[16:])
+	// header[20:32] holds ProviderType/Reserved1/Reserved2, then the null-terminated CSPName
+	// string; neither is needed now that EncryptionHeaderSize tells us where the header ends.
+
+	keyBits := int(keySize)
+	if keyBits == 0 {
+		keyBits = 40
+	}
+
+	blockSize := 0
+	if algID == 0x660E || algID == 0x660F || algID == 0x6610 {
+		// AES-128 / AES-192 / AES-256.
+		blockSize = 16
+	}
+
+	verifierStart := headerStart + encryptionHeaderSize
+	if verifierStart+4 > len(payload) {
+		return filePassInfo{}, io.ErrUnexpectedEOF
+	}
+	saltSize := int(binary.LittleEndian.Uint32(payload[verifierStart:]))
+	saltStart := verifierStart + 4
+	if saltSize < 0 || saltStart+saltSize+16+4 > len(payload) {
+		return filePassInfo{}, io.ErrUnexpectedEOF
+	}
+
+	salt := payload[saltStart : saltStart+saltSize]
+	verifier := payload[saltStart+saltSize : saltStart+saltSize+16]
+	hashSizeOffset := saltStart + saltSize + 16
+	hashSize := int(binary.LittleEndian.Uint32(payload[hashSizeOffset:]))
+	hashStart := hashSizeOffset + 4
+	if hashStart+hashSize > len(payload) {
+		hashSize = len(payload) - hashStart
+	}
+
+	return filePassInfo{
+		scheme:       schemeRC4CryptoAPI,
+		salt:         salt,
+		verifier:     verifier,
+		verifierHash: payload[hashStart : hashStart+hashSize],
+		keyBits:      keyBits,
+		blockSize:    blockSize,
+	}, nil
+}
+
+// decryptWorkbookStream decrypts every record payload in stream from the end of the FILEPASS
+// record onward, re-keying per recordBlockSize as the scheme requires. Each payload is decrypted
+// at its own byte offset within the stream. Record headers (id and length) are never encrypted.
+func decryptWorkbookStream(stream []byte, password string, info filePassInfo) ([]byte, error) {
+	out := make([]byte, len(stream))
+	reader := newDecryptingReader(password, info)
+	seenFilePass := false
+
+	pos := 0
+	for pos+4 <= len(stream) {
+		recID := binary.LittleEndian.Uint16(stream[pos:])
+		recLen := int(binary.LittleEndian.Uint16(stream[pos+2:]))
+		payloadStart := pos + 4
+		if payloadStart+recLen > len(stream) {
+			copy(out[pos:], stream[pos:])
+			break
+		}
+
+		copy(out[pos:payloadStart], stream[pos:payloadStart])
+
+		if !seenFilePass {
+			// Records up to and including FILEPASS itself are never encrypted.
+			copy(out[payloadStart:payloadStart+recLen], stream[payloadStart:payloadStart+recLen])
+			seenFilePass = recID == filePassRecordID
+		} else {
+			reader.position = int64(payloadStart)
+			decrypted, err := reader.Decrypt(stream[payloadStart : payloadStart+recLen])
+			if err != nil {
+				return nil, err
+			}
+			copy(out[payloadStart:payloadStart+recLen], decrypted)
+		}
+
+		pos = payloadStart + recLen
+	}
+
+	return out, nil
+}
@@ -0,0 +1,66 @@
+/*
+File Name:  password_test.go
+Copyright:  2019 Kleissner Investments s.r.o.
+Author:     Peter Kleissner
+*/
+
+package xls
+
+import "testing"
+
+// TestParseFilePassRecordRC4CryptoAPI builds a synthetic FILEPASS payload with a deliberately long
+// CSPName string ahead of the EncryptionVerifier, so a parser that hard-codes the header size
+// instead of reading EncryptionHeaderSize would read the salt/verifier/hash out of the wrong
+// offsets.
+func TestParseFilePassRecordRC4CryptoAPI(t *testing.T) {
+	payload := []byte{
+		0x01, 0x00, 0x03, 0x00, 0x02, 0x00, 0x04, 0x00, 0x00, 0x00, 0x8c, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10, 0x66,
+		0x00, 0x00, 0x04, 0x80, 0x00, 0x00, 0x80, 0x00, 0x00, 0x00, 0x01, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x4d, 0x00,
+		0x69, 0x00, 0x63, 0x00, 0x72, 0x00, 0x6f, 0x00, 0x73, 0x00, 0x6f, 0x00,
+		0x66, 0x00, 0x74, 0x00, 0x20, 0x00, 0x45, 0x00, 0x6e, 0x00, 0x68, 0x00,
+		0x61, 0x00, 0x6e, 0x00, 0x63, 0x00, 0x65, 0x00, 0x64, 0x00, 0x20, 0x00,
+		0x52, 0x00, 0x53, 0x00, 0x41, 0x00, 0x20, 0x00, 0x61, 0x00, 0x6e, 0x00,
+		0x64, 0x00, 0x20, 0x00, 0x41, 0x00, 0x45, 0x00, 0x53, 0x00, 0x20, 0x00,
+		0x43, 0x00, 0x72, 0x00, 0x79, 0x00, 0x70, 0x00, 0x74, 0x00, 0x6f, 0x00,
+		0x67, 0x00, 0x72, 0x00, 0x61, 0x00, 0x70, 0x00, 0x68, 0x00, 0x69, 0x00,
+		0x63, 0x00, 0x20, 0x00, 0x50, 0x00, 0x72, 0x00, 0x6f, 0x00, 0x76, 0x00,
+		0x69, 0x00, 0x64, 0x00, 0x65, 0x00, 0x72, 0x00, 0x00, 0x00, 0x10, 0x00,
+		0x00, 0x00, 0x20, 0x21, 0x22, 0x23, 0x24, 0x25, 0x26, 0x27, 0x28, 0x29,
+		0x2a, 0x2b, 0x2c, 0x2d, 0x2e, 0x2f, 0x40, 0x41, 0x42, 0x43, 0x44, 0x45,
+		0x46, 0x47, 0x48, 0x49, 0x4a, 0x4b, 0x4c, 0x4d, 0x4e, 0x4f, 0x14, 0x00,
+		0x00, 0x00, 0x60, 0x61, 0x62, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+		0x6a, 0x6b, 0x6c, 0x6d, 0x6e, 0x6f, 0x70, 0x71, 0x72, 0x73,
+	}
+
+	info, err := parseFilePassRecord(payload)
+	if err != nil {
+		t.Fatalf("parseFilePassRecord: %v", err)
+	}
+
+	if info.scheme != schemeRC4CryptoAPI {
+		t.Fatalf("scheme = %v, want schemeRC4CryptoAPI", info.scheme)
+	}
+	if info.keyBits != 128 {
+		t.Errorf("keyBits = %d, want 128", info.keyBits)
+	}
+	if info.blockSize != 16 {
+		t.Errorf("blockSize = %d, want 16 (AES)", info.blockSize)
+	}
+
+	wantSalt := []byte{0x20, 0x21, 0x22, 0x23, 0x24, 0x25, 0x26, 0x27, 0x28, 0x29, 0x2a, 0x2b, 0x2c, 0x2d, 0x2e, 0x2f}
+	if string(info.salt) != string(wantSalt) {
+		t.Errorf("salt = % x, want % x", info.salt, wantSalt)
+	}
+
+	wantVerifier := []byte{0x40, 0x41, 0x42, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49, 0x4a, 0x4b, 0x4c, 0x4d, 0x4e, 0x4f}
+	if string(info.verifier) != string(wantVerifier) {
+		t.Errorf("verifier = % x, want % x", info.verifier, wantVerifier)
+	}
+
+	wantHash := []byte{0x60, 0x61, 0x62, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69, 0x6a, 0x6b, 0x6c, 0x6d, 0x6e, 0x6f, 0x70, 0x71, 0x72, 0x73}
+	if string(info.verifierHash) != string(wantHash) {
+		t.Errorf("verifierHash = % x, want % x", info.verifierHash, wantHash)
+	}
+}
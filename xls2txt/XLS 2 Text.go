@@ -67,9 +67,18 @@ func XLS2Text(reader io.ReadSeeker, writer io.Writer, size int64) (written int64
 	return written, nil
 }
 
-// XLS2CSV converts selected sheet of the XLS file into CSV format.
+// XLS2CSV converts selected sheet of the XLS file into CSV format, per RFC 4180. Multi-line cells
+// keep their embedded newlines (in a quoted field); use XLS2CSVWithOptions to collapse them instead
+// or to enforce size limits.
 func XLS2CSV(reader io.ReadSeeker, sheetNumber int) ([]byte, error) {
-	xlFile, err := xls.OpenReader(reader, "utf-8")
+	return XLS2CSVWithOptions(reader, sheetNumber, CSVOptions{PreserveNewlines: true})
+}
+
+// XLS2CSVWithOptions is identical to XLS2CSV, except the CSV delimiter, line terminator and
+// newline handling are controlled by opts, and opts.Options bounds the resources spent reading
+// the workbook (see xls.Options).
+func XLS2CSVWithOptions(reader io.ReadSeeker, sheetNumber int, opts CSVOptions) ([]byte, error) {
+	xlFile, err := xls.OpenReaderWithOptions(reader, "utf-8", opts.Options)
 	if err != nil || xlFile == nil {
 		return nil, err
 	}
@@ -79,8 +88,14 @@ func XLS2CSV(reader io.ReadSeeker, sheetNumber int) ([]byte, error) {
 		return nil, errors.New("sheet doesn't exist")
 	}
 
-	rows := make([]string, 0)
-	for ii := 0; ii < int(sheet.MaxRow); ii++ {
+	maxRow := int(sheet.MaxRow)
+	if opts.MaxRowsPerSheet > 0 && maxRow > opts.MaxRowsPerSheet-1 {
+		maxRow = opts.MaxRowsPerSheet - 1
+	}
+
+	cellCount := 0
+	rows := make([][]string, 0)
+	for ii := 0; ii <= maxRow; ii++ {
 		row := sheet.Row(ii)
 		if row == nil {
 			continue
@@ -88,13 +103,21 @@ func XLS2CSV(reader io.ReadSeeker, sheetNumber int) ([]byte, error) {
 
 		columns := make([]string, 0)
 		for jj := row.FirstCol(); jj < row.LastCol(); jj++ {
-			columns = append(columns, WrapCSVCell(row.Col(jj)))
+			text := row.Col(jj)
+			if text != "" {
+				cellCount++
+				if opts.MaxCellCount > 0 && cellCount > opts.MaxCellCount {
+					return nil, xls.ErrLimitExceeded
+				}
+			}
+
+			columns = append(columns, clipCellValue(cellText(text, opts), opts.CellValueMaxLen))
 		}
 
-		rows = append(rows, strings.Join(columns, ","))
+		rows = append(rows, columns)
 	}
 
-	return []byte(strings.Join(rows, "\n")), nil
+	return writeCSVRows(rows, opts)
 }
 
 // cleanCell returns a cleaned cell text without new-lines
@@ -106,10 +129,6 @@ func cleanCell(text string) string {
 	return text
 }
 
-func WrapCSVCell(cell string) string {
-	return "\"" + cleanCell(cell) + "\""
-}
-
 func xlGenerateSheetTitle(name string, number, rows int) (title string) {
 	if number > 0 {
 		title += "\n"
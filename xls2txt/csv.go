@@ -0,0 +1,60 @@
+/*
+File Name:  csv.go
+Copyright:  2019 Kleissner Investments s.r.o.
+Author:     Peter Kleissner
+
+RFC 4180 CSV writing shared by XLS2CSV and its WithOptions/WithFormatting variants. Replaces the
+old hand-rolled WrapCSVCell, which mangled any cell containing a literal quote, an embedded comma,
+or a newline (cleanCell unconditionally stripped those, losing data).
+*/
+
+package xls2txt
+
+import (
+	"bytes"
+	"encoding/csv"
+
+	"github.com/FleetWiser/fileconversion/xls"
+)
+
+// CSVOptions controls how XLS2CSVWithOptions formats output and, via the embedded xls.Options,
+// what size limits it enforces while reading the workbook.
+type CSVOptions struct {
+	xls.Options
+
+	Comma            rune // field delimiter, ',' when zero
+	UseCRLF          bool // use \r\n as the line terminator, per RFC 4180
+	PreserveNewlines bool // keep \n characters inside a cell instead of collapsing them to a space
+}
+
+// cellText returns cell ready to hand to encoding/csv: newlines are either preserved (csv.Writer
+// quotes the field automatically) or collapsed to match the old cleanCell behavior.
+func cellText(cell string, opts CSVOptions) string {
+	if opts.PreserveNewlines {
+		return cell
+	}
+	return cleanCell(cell)
+}
+
+// writeCSVRows renders rows as RFC 4180 CSV according to opts.
+func writeCSVRows(rows [][]string, opts CSVOptions) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if opts.Comma != 0 {
+		w.Comma = opts.Comma
+	}
+	w.UseCRLF = opts.UseCRLF
+
+	for _, row := range rows {
+		if err := w.Write(row); err != nil {
+			return nil, err
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,68 @@
+/*
+File Name:  csv_test.go
+Copyright:  2019 Kleissner Investments s.r.o.
+Author:     Peter Kleissner
+*/
+
+package xls2txt
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCellText(t *testing.T) {
+	tests := []struct {
+		name string
+		cell string
+		opts CSVOptions
+		want string
+	}{
+		{"preserve newlines", "line1\nline2", CSVOptions{PreserveNewlines: true}, "line1\nline2"},
+		{"collapse newlines", "line1\nline2", CSVOptions{}, "line1 line2"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := cellText(tc.cell, tc.opts); got != tc.want {
+				t.Errorf("cellText(%q, %+v) = %q, want %q", tc.cell, tc.opts, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWriteCSVRowsRFC4180(t *testing.T) {
+	rows := [][]string{
+		{"a", "b,c", "d\"e"},
+		{"line1\nline2", "f", "g"},
+	}
+
+	out, err := writeCSVRows(rows, CSVOptions{PreserveNewlines: true})
+	if err != nil {
+		t.Fatalf("writeCSVRows: %v", err)
+	}
+
+	got := string(out)
+	if !strings.Contains(got, `"b,c"`) {
+		t.Errorf("expected comma-containing field to be quoted, got %q", got)
+	}
+	if !strings.Contains(got, `"d""e"`) {
+		t.Errorf("expected embedded quote to be doubled and the field quoted, got %q", got)
+	}
+	if !strings.Contains(got, "\"line1\nline2\"") {
+		t.Errorf("expected embedded newline to be preserved in a quoted field, got %q", got)
+	}
+}
+
+func TestWriteCSVRowsCustomDelimiter(t *testing.T) {
+	rows := [][]string{{"a", "b"}}
+
+	out, err := writeCSVRows(rows, CSVOptions{Comma: ';'})
+	if err != nil {
+		t.Fatalf("writeCSVRows: %v", err)
+	}
+
+	if !strings.HasPrefix(string(out), "a;b") {
+		t.Errorf("expected custom delimiter ';' in output, got %q", out)
+	}
+}
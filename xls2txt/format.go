@@ -0,0 +1,110 @@
+/*
+File Name:  format.go
+Copyright:  2019 Kleissner Investments s.r.o.
+Author:     Peter Kleissner
+
+XLS2TextWithFormatting and XLS2CSVWithFormatting render each cell through Row.FormattedCol instead
+of returning its raw stored string, so formulas and date/currency number formats show up the way
+Excel would display them. The XF number-format code and any cached formula result come from
+xls.BuildCellFormats, which scans the workbook's raw BIFF8 records independently of xls.OpenReader;
+if that scan fails (e.g. an encrypted or otherwise unreadable stream), formatting degrades to a
+no-op rather than failing the whole extraction.
+*/
+
+package xls2txt
+
+import (
+	"errors"
+	"io"
+
+	"github.com/FleetWiser/fileconversion/xls"
+)
+
+// XLS2TextWithFormatting is identical to XLS2Text, except cells are rendered through
+// Row.FormattedCol according to opts instead of returned as their raw stored string.
+func XLS2TextWithFormatting(reader io.ReadSeeker, writer io.Writer, size int64, opts xls.TextOptions) (written int64, err error) {
+	formats, _ := xls.BuildCellFormats(reader)
+
+	if _, err := reader.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	xlFile, err := xls.OpenReader(reader, "utf-8")
+	if err != nil || xlFile == nil {
+		return 0, err
+	}
+
+	for n := 0; n < xlFile.NumSheets(); n++ {
+		sheet1 := xlFile.GetSheet(n)
+		if sheet1 == nil {
+			continue
+		}
+
+		if err = writeOutput(writer, []byte(xlGenerateSheetTitle(sheet1.Name, n, int(sheet1.MaxRow))), &written, &size); err != nil || size == 0 {
+			return written, err
+		}
+
+		for m := 0; m <= int(sheet1.MaxRow); m++ {
+			row1 := sheet1.Row(m)
+			if row1 == nil {
+				continue
+			}
+
+			rowText := ""
+
+			for c := row1.FirstCol(); c < row1.LastCol(); c++ {
+				if text := row1.FormattedCol(c, formats.Get(n, m, c), opts); text != "" {
+					text = cleanCell(text)
+
+					if c > row1.FirstCol() {
+						rowText += ", "
+					}
+					rowText += text
+				}
+			}
+
+			rowText += "\n"
+
+			if err = writeOutput(writer, []byte(rowText), &written, &size); err != nil || size == 0 {
+				return written, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// XLS2CSVWithFormatting is identical to XLS2CSV, except cells are rendered through
+// Row.FormattedCol according to opts instead of returned as their raw stored string.
+func XLS2CSVWithFormatting(reader io.ReadSeeker, sheetNumber int, opts xls.TextOptions) ([]byte, error) {
+	formats, _ := xls.BuildCellFormats(reader)
+
+	if _, err := reader.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	xlFile, err := xls.OpenReader(reader, "utf-8")
+	if err != nil || xlFile == nil {
+		return nil, err
+	}
+
+	sheet := xlFile.GetSheet(sheetNumber)
+	if nil == sheet {
+		return nil, errors.New("sheet doesn't exist")
+	}
+
+	rows := make([][]string, 0)
+	for ii := 0; ii <= int(sheet.MaxRow); ii++ {
+		row := sheet.Row(ii)
+		if row == nil {
+			continue
+		}
+
+		columns := make([]string, 0)
+		for jj := row.FirstCol(); jj < row.LastCol(); jj++ {
+			columns = append(columns, row.FormattedCol(jj, formats.Get(sheetNumber, ii, jj), opts))
+		}
+
+		rows = append(rows, columns)
+	}
+
+	return writeCSVRows(rows, CSVOptions{PreserveNewlines: true})
+}
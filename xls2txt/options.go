@@ -0,0 +1,123 @@
+/*
+File Name:  options.go
+Copyright:  2019 Kleissner Investments s.r.o.
+Author:     Peter Kleissner
+
+XLS2TextWithOptions and XLS2CellsWithOptions are the size-limited variants of XLS2Text and
+XLS2Cells, guarding against a maliciously crafted .xls file ballooning memory. See xls.Options for
+the limits that are enforced. The CSV equivalent, XLS2CSVWithOptions, lives in "XLS 2 Text.go"
+alongside XLS2CSV since its options type (CSVOptions) also carries CSV-specific formatting knobs.
+*/
+
+package xls2txt
+
+import (
+	"io"
+
+	"github.com/FleetWiser/fileconversion/xls"
+)
+
+// XLS2TextWithOptions is identical to XLS2Text, except it enforces xls.Options while walking sheets and rows.
+func XLS2TextWithOptions(reader io.ReadSeeker, writer io.Writer, size int64, opts xls.Options) (written int64, err error) {
+	xlFile, err := xls.OpenReaderWithOptions(reader, "utf-8", opts)
+	if err != nil || xlFile == nil {
+		return 0, err
+	}
+
+	cellCount := 0
+
+	for n := 0; n < xlFile.NumSheets(); n++ {
+		sheet1 := xlFile.GetSheet(n)
+		if sheet1 == nil {
+			continue
+		}
+
+		if err = writeOutput(writer, []byte(xlGenerateSheetTitle(sheet1.Name, n, int(sheet1.MaxRow))), &written, &size); err != nil || size == 0 {
+			return written, err
+		}
+
+		maxRow := int(sheet1.MaxRow)
+		if opts.MaxRowsPerSheet > 0 && maxRow > opts.MaxRowsPerSheet-1 {
+			maxRow = opts.MaxRowsPerSheet - 1
+		}
+
+		for m := 0; m <= maxRow; m++ {
+			row1 := sheet1.Row(m)
+			if row1 == nil {
+				continue
+			}
+
+			rowText := ""
+
+			for c := row1.FirstCol(); c < row1.LastCol(); c++ {
+				if text := row1.Col(c); text != "" {
+					text = clipCellValue(cleanCell(text), opts.CellValueMaxLen)
+
+					cellCount++
+					if opts.MaxCellCount > 0 && cellCount > opts.MaxCellCount {
+						return written, xls.ErrLimitExceeded
+					}
+
+					if c > row1.FirstCol() {
+						rowText += ", "
+					}
+					rowText += text
+				}
+			}
+
+			rowText += "\n"
+
+			if err = writeOutput(writer, []byte(rowText), &written, &size); err != nil || size == 0 {
+				return written, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// XLS2CellsWithOptions is identical to XLS2Cells, except it enforces xls.Options while walking sheets and rows.
+func XLS2CellsWithOptions(reader io.ReadSeeker, opts xls.Options) (cells []string, err error) {
+	xlFile, err := xls.OpenReaderWithOptions(reader, "utf-8", opts)
+	if err != nil || xlFile == nil {
+		return nil, err
+	}
+
+	for n := 0; n < xlFile.NumSheets(); n++ {
+		sheet1 := xlFile.GetSheet(n)
+		if sheet1 == nil {
+			continue
+		}
+
+		maxRow := int(sheet1.MaxRow)
+		if opts.MaxRowsPerSheet > 0 && maxRow > opts.MaxRowsPerSheet-1 {
+			maxRow = opts.MaxRowsPerSheet - 1
+		}
+
+		for m := 0; m <= maxRow; m++ {
+			row1 := sheet1.Row(m)
+			if row1 == nil {
+				continue
+			}
+
+			for c := row1.FirstCol(); c < row1.LastCol(); c++ {
+				if text := row1.Col(c); text != "" {
+					if opts.MaxCellCount > 0 && len(cells) >= opts.MaxCellCount {
+						return cells, xls.ErrLimitExceeded
+					}
+					cells = append(cells, clipCellValue(cleanCell(text), opts.CellValueMaxLen))
+				}
+			}
+		}
+	}
+
+	return cells, nil
+}
+
+// clipCellValue truncates a cell's text to maxLen, leaving it untouched when maxLen is 0.
+func clipCellValue(text string, maxLen int) string {
+	if maxLen > 0 && len(text) > maxLen {
+		return text[:maxLen]
+	}
+	return text
+}
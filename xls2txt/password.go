@@ -0,0 +1,65 @@
+/*
+File Name:  password.go
+Copyright:  2019 Kleissner Investments s.r.o.
+Author:     Peter Kleissner
+
+XLS2TextWithPassword opens a possibly password-protected workbook, surfacing
+xls.ErrPasswordRequired / xls.ErrWrongPassword so callers can prompt for a password instead of
+silently getting back 0 bytes the way XLS2Text does today.
+*/
+
+package xls2txt
+
+import (
+	"io"
+
+	"github.com/FleetWiser/fileconversion/xls"
+)
+
+// XLS2TextWithPassword is identical to XLS2Text, except it decrypts the workbook with password
+// first. password may be empty for unencrypted files.
+func XLS2TextWithPassword(reader io.ReadSeeker, writer io.Writer, size int64, password string) (written int64, err error) {
+	xlFile, err := xls.OpenReaderWithPassword(reader, "utf-8", password)
+	if err != nil || xlFile == nil {
+		return 0, err
+	}
+
+	for n := 0; n < xlFile.NumSheets(); n++ {
+		sheet1 := xlFile.GetSheet(n)
+		if sheet1 == nil {
+			continue
+		}
+
+		if err = writeOutput(writer, []byte(xlGenerateSheetTitle(sheet1.Name, n, int(sheet1.MaxRow))), &written, &size); err != nil || size == 0 {
+			return written, err
+		}
+
+		for m := 0; m <= int(sheet1.MaxRow); m++ {
+			row1 := sheet1.Row(m)
+			if row1 == nil {
+				continue
+			}
+
+			rowText := ""
+
+			for c := row1.FirstCol(); c < row1.LastCol(); c++ {
+				if text := row1.Col(c); text != "" {
+					text = cleanCell(text)
+
+					if c > row1.FirstCol() {
+						rowText += ", "
+					}
+					rowText += text
+				}
+			}
+
+			rowText += "\n"
+
+			if err = writeOutput(writer, []byte(rowText), &written, &size); err != nil || size == 0 {
+				return written, err
+			}
+		}
+	}
+
+	return written, nil
+}
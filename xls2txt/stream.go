@@ -0,0 +1,117 @@
+/*
+File Name:  stream.go
+Copyright:  2019 Kleissner Investments s.r.o.
+Author:     Peter Kleissner
+
+Streaming row-by-row API modeled on excelize's Rows iterator. xls.OpenReader still buffers the
+entire input workbook in memory (there is no streaming BIFF8 decoder), so the benefit here is
+bounded *output* memory: callers like XLS2CSVStream can write one row at a time instead of
+collecting every row before writing any of them.
+*/
+
+package xls2txt
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+
+	"github.com/FleetWiser/fileconversion/xls"
+)
+
+// RowIter walks every sheet of a workbook row by row, letting a caller consume and discard each
+// row before moving to the next instead of collecting them all up front.
+type RowIter struct {
+	xlFile     *xls.WorkBook
+	sheetIndex int
+	rowIndex   int
+}
+
+// NewXLSRowIterator opens reader and returns an iterator over all of its sheets. Note that
+// xls.OpenReader reads the whole input workbook into memory before this function returns;
+// iterating bounds how much of the *decoded* output a caller has to hold onto at once, not how
+// much of the source file is buffered.
+func NewXLSRowIterator(reader io.ReadSeeker) (*RowIter, error) {
+	xlFile, err := xls.OpenReader(reader, "utf-8")
+	if err != nil || xlFile == nil {
+		return nil, err
+	}
+
+	return &RowIter{xlFile: xlFile, rowIndex: -1}, nil
+}
+
+// Next advances to the next non-empty row and returns its sheet index, row index and cell text.
+// ok is false once every sheet has been exhausted.
+func (it *RowIter) Next() (sheetIndex, rowIndex int, cells []string, ok bool) {
+	for it.sheetIndex < it.xlFile.NumSheets() {
+		sheet := it.xlFile.GetSheet(it.sheetIndex)
+		if sheet == nil {
+			it.sheetIndex++
+			it.rowIndex = -1
+			continue
+		}
+
+		it.rowIndex++
+		if it.rowIndex > int(sheet.MaxRow) {
+			it.sheetIndex++
+			it.rowIndex = -1
+			continue
+		}
+
+		row := sheet.Row(it.rowIndex)
+		if row == nil {
+			continue
+		}
+
+		cells = make([]string, 0, row.LastCol()-row.FirstCol())
+		for c := row.FirstCol(); c < row.LastCol(); c++ {
+			cells = append(cells, cleanCell(row.Col(c)))
+		}
+
+		return it.sheetIndex, it.rowIndex, cells, true
+	}
+
+	return 0, 0, nil, false
+}
+
+// XLS2CSVStream writes the selected sheet directly to w as CSV, one row at a time, instead of
+// buffering the whole sheet in memory like XLS2CSV does.
+func XLS2CSVStream(reader io.ReadSeeker, sheetNumber int, w io.Writer) error {
+	it, err := NewXLSRowIterator(reader)
+	if err != nil {
+		return err
+	}
+
+	csvWriter := csv.NewWriter(w)
+	found := false
+
+	for {
+		sheetIndex, _, cells, ok := it.Next()
+		if !ok {
+			break
+		}
+		if sheetIndex < sheetNumber {
+			continue
+		}
+		if sheetIndex > sheetNumber {
+			break
+		}
+
+		found = true
+
+		if err := csvWriter.Write(cells); err != nil {
+			return err
+		}
+
+		csvWriter.Flush()
+		if err := csvWriter.Error(); err != nil {
+			return err
+		}
+	}
+
+	if !found {
+		return errors.New("sheet doesn't exist")
+	}
+
+	return nil
+}
@@ -0,0 +1,175 @@
+/*
+File Name:  xlsx2txt.go
+Copyright:  2019 Kleissner Investments s.r.o.
+Author:     Peter Kleissner
+
+Sibling of the xls2txt package for the OOXML (.xlsx) format. Wraps github.com/xuri/excelize/v2
+and mirrors the public surface of xls2txt so callers can treat both formats the same way.
+*/
+
+package xlsx2txt
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// XLSX2Text extracts text from an Excel sheet. It returns bytes written.
+// The parameter size is the max amount of bytes (not characters) to write out.
+// The whole Excel file is required even for partial text extraction. For a corrupted or invalid file it returns 0 bytes written and the error from opening it.
+func XLSX2Text(reader io.Reader, writer io.Writer, size int64) (written int64, err error) {
+
+	xlFile, err := excelize.OpenReader(reader)
+	if err != nil || xlFile == nil {
+		return 0, err
+	}
+	defer xlFile.Close()
+
+	for n, sheetName := range xlFile.GetSheetList() {
+		rows, err := xlFile.GetRows(sheetName)
+		if err != nil {
+			continue
+		}
+
+		if err = writeOutput(writer, []byte(xlGenerateSheetTitle(sheetName, n, len(rows))), &written, &size); err != nil || size == 0 {
+			return written, err
+		}
+
+		for _, row := range rows {
+			rowText := ""
+
+			// go through all columns
+			for c, cell := range row {
+				if cell == "" {
+					continue
+				}
+				cell = cleanCell(cell)
+
+				if c > 0 {
+					rowText += ", "
+				}
+				rowText += cell
+			}
+
+			rowText += "\n"
+
+			if err = writeOutput(writer, []byte(rowText), &written, &size); err != nil || size == 0 {
+				return written, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// XLSX2CSV converts selected sheet of the XLSX file into CSV format, per RFC 4180. Multi-line
+// cells keep their embedded newlines in a quoted field.
+func XLSX2CSV(reader io.Reader, sheetNumber int) ([]byte, error) {
+	xlFile, err := excelize.OpenReader(reader)
+	if err != nil || xlFile == nil {
+		return nil, err
+	}
+	defer xlFile.Close()
+
+	sheetNames := xlFile.GetSheetList()
+	if sheetNumber < 0 || sheetNumber >= len(sheetNames) {
+		return nil, errors.New("sheet doesn't exist")
+	}
+
+	xlRows, err := xlFile.GetRows(sheetNames[sheetNumber])
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	for _, row := range xlRows {
+		if err := w.Write(row); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// XLSX2Cells converts an XLSX file to individual cells
+func XLSX2Cells(reader io.Reader) (cells []string, err error) {
+	xlFile, err := excelize.OpenReader(reader)
+	if err != nil || xlFile == nil {
+		return nil, err
+	}
+	defer xlFile.Close()
+
+	for _, sheetName := range xlFile.GetSheetList() {
+		rows, err := xlFile.GetRows(sheetName)
+		if err != nil {
+			continue
+		}
+
+		for _, row := range rows {
+			for _, cell := range row {
+				if cell == "" {
+					continue
+				}
+				cells = append(cells, cleanCell(cell))
+			}
+		}
+	}
+
+	return
+}
+
+// cleanCell returns a cleaned cell text without new-lines
+func cleanCell(text string) string {
+	text = strings.ReplaceAll(text, "\n", " ")
+	text = strings.ReplaceAll(text, "\r", "")
+	text = strings.TrimSpace(text)
+
+	return text
+}
+
+func xlGenerateSheetTitle(name string, number, rows int) (title string) {
+	if number > 0 {
+		title += "\n"
+	}
+
+	title += fmt.Sprintf("Sheet \"%s\" (%d rows):\n", name, rows)
+
+	return title
+}
+
+func writeOutput(writer io.Writer, output []byte, alreadyWritten *int64, size *int64) (err error) {
+
+	if int64(len(output)) > *size {
+		output = output[:*size]
+	}
+
+	*size -= int64(len(output))
+
+	writtenOut, err := writer.Write(output)
+	*alreadyWritten += int64(writtenOut)
+
+	return err
+}
+
+// IsFileXLSX checks if the data indicates an OOXML (.xlsx) file.
+// XLSX files are zip archives (PK signature) that contain a top-level [Content_Types].xml part.
+func IsFileXLSX(data []byte) bool {
+	if !bytes.HasPrefix(data, []byte{0x50, 0x4B, 0x03, 0x04}) &&
+		!bytes.HasPrefix(data, []byte{0x50, 0x4B, 0x05, 0x06}) &&
+		!bytes.HasPrefix(data, []byte{0x50, 0x4B, 0x07, 0x08}) {
+		return false
+	}
+
+	return bytes.Contains(data, []byte("[Content_Types].xml"))
+}